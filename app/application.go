@@ -2,11 +2,19 @@ package app
 
 import (
 	"context"
+	"mashaghel/handler/routers"
 	"mashaghel/internal/config"
+	"mashaghel/internal/helper/nats"
+	"mashaghel/internal/producers"
 	"mashaghel/internal/tasks"
+	"net"
+	"time"
 
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/sdk/metric"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 type Application interface {
@@ -22,38 +30,53 @@ func NewApplication(ctx context.Context, config *config.Config) Application {
 	return &application{ctx: ctx, config: config}
 }
 
+// stopTimeout leaves the task manager its drain timeout plus some time to
+// close the connections
+func (a *application) stopTimeout() time.Duration {
+	drainTimeout := a.config.WorkerPool.DrainTimeout
+	if drainTimeout == 0 {
+		drainTimeout = 30
+	}
+	return time.Duration(drainTimeout)*time.Second + 15*time.Second
+}
+
 // bootstrap
 
 func (a *application) Setup() {
 	app := fx.New(
+		fx.StopTimeout(a.stopTimeout()),
 		fx.Provide(
-			// a.InitRouter,
-			// a.InitFramework,
-			// a.InitController,
-			// a.InitServices,
-			// a.InitRepositories,
-			// a.InitRedis,
-			// a.InitArangoDB,
+			a.InitRouter,
+			a.InitFramework,
+			a.InitController,
+			a.InitServices,
+			a.InitRepositories,
+			a.InitRedis,
+			a.InitArangoDB,
 			a.InitScyllaDB,
 			a.InitLogger,
 			a.InitTracerProvider,
-			// a.InitGRPCServer,
-			// a.InitNats,
+			a.InitMeterProvider,
+			a.InitGRPCServer,
+			a.InitNats,
 			a.InitTask,
 		),
 
-		// fx.Invoke(func(lc fx.Lifecycle, connection nats.NatsConnection, logger *zap.Logger) {
-		// 	lc.Append(fx.Hook{
-		// 		OnStart: func(_ context.Context) error {
-		// 			logger.Info("Starting nats connection")
-		// 			return nil
-		// 		},
-		// 		OnStop: func(ctx context.Context) error {
-		// 			connection.Close()
-		// 			return nil
-		// 		},
-		// 	})
-		// }),
+		fx.Invoke(func(lc fx.Lifecycle, connection nats.NatsConnection, logger *zap.Logger) {
+			lc.Append(fx.Hook{
+				OnStart: func(_ context.Context) error {
+					logger.Info("Starting nats connection")
+					return nil
+				},
+				OnStop: func(ctx context.Context) error {
+					logger.Info("Closing nats connection ...")
+					return connection.Close(ctx)
+				},
+			})
+		}),
+
+		// Consumers are stopped before the connection is closed
+		fx.Invoke(a.StartConsumers),
 
 		// fx.Invoke(func(lc fx.Lifecycle, nats nats.NatsConnection, logger *zap.Logger) {
 		// 	lc.Append(fx.Hook{
@@ -68,58 +91,33 @@ func (a *application) Setup() {
 		// 	})
 		// }),
 
-		// fx.Invoke(func(lc fx.Lifecycle, grpcServer *grpc.Server, logger *zap.Logger) {
-		// 	logger.Info("Initializing gRPC server")
-		// 	lc.Append(fx.Hook{
-		// 		OnStart: func(_ context.Context) error {
-		// 			listener, err := net.Listen("tcp", a.config.GRPC.Host+":"+a.config.GRPC.Port)
-		// 			if err != nil {
-		// 				logger.Info("Failed to listen on gRPC port", zap.Error(err))
-		// 				return err
-		// 			}
-		// 			logger.Info("starting gRPC Server",
-		// 				zap.String("host", a.config.GRPC.Host),
-		// 				zap.String("port", a.config.GRPC.Port),
-		// 			)
-		// 			go func() {
-		// 				if err := grpcServer.Serve(listener); err != nil {
-		// 					logger.Info("Failed to serve gRPC", zap.Error(err))
-		// 				}
-		// 			}()
-		// 			// log.Println("gRPC server started on", a.config.GRPC.Host+":"+a.config.GRPC.Port)
-		// 			return nil
-		// 		},
-		// 		OnStop: func(_ context.Context) error {
-		// 			grpcServer.Stop()
-		// 			logger.Info("gRPC server stopped")
-		// 			logger.Sync()
-		// 			return nil
-		// 		},
-		// 	})
-		// }),
+		fx.Invoke(func(lc fx.Lifecycle, redis producers.RedisClient, logger *zap.Logger) {
+			lc.Append(fx.Hook{
+				OnStop: func(_ context.Context) error {
+					logger.Info("Closing redis connection ...")
+					return redis.Close()
+				},
+			})
+		}),
 
-		// fx.Invoke(func(lc fx.Lifecycle, app *fiber.App, logger *zap.Logger) {
-		// 	// Start Fiber server in a separate goroutine
-		// 	lc.Append(fx.Hook{
-		// 		OnStart: func(_ context.Context) error {
-		// 			logger.Info("Starting Fiber server")
-		// 			go func() {
-		// 				if err := app.Listen(a.config.Server.Host + ":" + a.config.Server.Port); err != nil {
-		// 					logger.Error("Failed to start Fiber server", zap.Error(err))
-		// 				}
-		// 			}()
-		// 			return nil
-		// 		},
-		// 		OnStop: func(ctx context.Context) error {
-		// 			logger.Sync()
-		// 			return nil
-		// 		},
-		// 	})
-		// }),
+		fx.Invoke(func(lc fx.Lifecycle, logger *zap.Logger) {
+			shutdown := a.InitTracer()
+			lc.Append(fx.Hook{
+				OnStop: func(ctx context.Context) error {
+					logger.Info("Flushing traces ...")
+					return shutdown(ctx)
+				},
+			})
+		}),
 
-		// fx.Invoke(func(app *fiber.App, router routers.Router) {
-		// 	router.AddRoutes(app.Group(""))
-		// }),
+		fx.Invoke(func(lc fx.Lifecycle, provider *metric.MeterProvider, logger *zap.Logger) {
+			lc.Append(fx.Hook{
+				OnStop: func(ctx context.Context) error {
+					logger.Info("Flushing metrics ...")
+					return provider.Shutdown(ctx)
+				},
+			})
+		}),
 
 		fx.Invoke(func(lc fx.Lifecycle, t tasks.Task, logger *zap.Logger) {
 			// Start workerpool
@@ -133,9 +131,74 @@ func (a *application) Setup() {
 					return nil
 				},
 				OnStop: func(ctx context.Context) error {
-					t.Stop()
+					return t.Stop(ctx)
+				},
+			})
+		}),
+
+		// The servers are started last, so they are stopped first and no
+		// request reaches a closed connection
+		fx.Invoke(func(lc fx.Lifecycle, grpcServer *grpc.Server, logger *zap.Logger) {
+			lc.Append(fx.Hook{
+				OnStart: func(_ context.Context) error {
+					listener, err := net.Listen("tcp", net.JoinHostPort(a.config.GRPC.Host, a.config.GRPC.Port))
+					if err != nil {
+						logger.Error("Failed to listen on gRPC port", zap.Error(err))
+						return err
+					}
+					logger.Info("Starting gRPC server",
+						zap.String("host", a.config.GRPC.Host),
+						zap.String("port", a.config.GRPC.Port),
+					)
+					go func() {
+						if err := grpcServer.Serve(listener); err != nil {
+							logger.Error("Failed to serve gRPC", zap.Error(err))
+						}
+					}()
+					return nil
+				},
+				OnStop: func(ctx context.Context) error {
+					logger.Info("Stopping gRPC server ...")
+					stopped := make(chan struct{})
+					go func() {
+						grpcServer.GracefulStop()
+						close(stopped)
+					}()
+					select {
+					case <-stopped:
+					case <-ctx.Done():
+						// Cancels the calls that are still running
+						grpcServer.Stop()
+					}
+					return nil
+				},
+			})
+		}),
+
+		// The router registers its routes on the app when it is created
+		fx.Invoke(func(lc fx.Lifecycle, app *fiber.App, _ routers.Router, logger *zap.Logger) {
+			lc.Append(fx.Hook{
+				OnStart: func(_ context.Context) error {
+					listener, err := net.Listen("tcp", net.JoinHostPort(a.config.Server.Host, a.config.Server.Port))
+					if err != nil {
+						logger.Error("Failed to listen on server port", zap.Error(err))
+						return err
+					}
+					logger.Info("Starting Fiber server",
+						zap.String("host", a.config.Server.Host),
+						zap.String("port", a.config.Server.Port),
+					)
+					go func() {
+						if err := app.Listener(listener); err != nil {
+							logger.Error("Failed to serve Fiber", zap.Error(err))
+						}
+					}()
 					return nil
 				},
+				OnStop: func(ctx context.Context) error {
+					logger.Info("Stopping Fiber server ...")
+					return app.ShutdownWithContext(ctx)
+				},
 			})
 		}),
 	)
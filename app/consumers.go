@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/consumers"
+	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/helper/nats"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// StartConsumers runs the enabled consumers of nats.consumers for the
+// lifetime of the application
+func (a *application) StartConsumers(lc fx.Lifecycle, connection nats.NatsConnection, scyllaDB scylla.ScyllaDB, logger *zap.Logger) {
+	handlers := consumers.NewHandlers(scyllaDB, "", logger)
+	var running []nats.Consumer
+
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			for name, cfg := range a.config.Nats.Consumers {
+				if !cfg.Enabled {
+					continue
+				}
+				handler, ok := handlers[name]
+				if !ok {
+					logger.Warn("Configured consumer is not registered", zap.String("consumer", name))
+					continue
+				}
+				consumer, err := connection.Consume(name, cfg, handler)
+				if err != nil {
+					return err
+				}
+				running = append(running, consumer)
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			logger.Info("Stopping consumers ...")
+			var errs []error
+			for _, consumer := range running {
+				errs = append(errs, consumer.Stop(ctx))
+			}
+			return errors.Join(errs...)
+		},
+	})
+}
@@ -1,7 +1,15 @@
 package app
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
 
 func (a *application) InitFramework() *fiber.App {
-	return fiber.New()
+	return fiber.New(fiber.Config{
+		ReadTimeout:           time.Duration(a.config.Server.ReadTimeout) * time.Second,
+		WriteTimeout:          time.Duration(a.config.Server.WriteTimeout) * time.Second,
+		DisableStartupMessage: true,
+	})
 }
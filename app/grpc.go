@@ -2,6 +2,7 @@ package app
 
 import (
 	"mashaghel/handler/controllers"
+	"mashaghel/handler/middlewares"
 	rpc_service "mashaghel/proto"
 
 	"go.uber.org/zap"
@@ -10,7 +11,9 @@ import (
 )
 
 func (a *application) InitGRPCServer(controller controllers.Controllers, logger *zap.Logger) *grpc.Server {
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(middlewares.AdminUnaryInterceptor(a.config.Server.Admin, rpc_service.RpcService_RunJob_FullMethodName)),
+	)
 	// Register server with controller
 	rpc_service.RegisterRpcServiceServer(grpcServer, controller.RpcServiceController())
 
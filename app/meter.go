@@ -0,0 +1,53 @@
+package app
+
+import (
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+)
+
+const defaultMetricInterval = 15 // In seconds
+
+// InitMeterProvider exports the metrics of the global meter provider to the
+// OTLP collector of the tracer config
+func (a *application) InitMeterProvider(logger *zap.Logger) *metric.MeterProvider {
+	var secureOption otlpmetricgrpc.Option
+
+	if strings.ToLower(a.config.Tracer.Insecure) == "false" {
+		secureOption = otlpmetricgrpc.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, ""))
+	} else {
+		secureOption = otlpmetricgrpc.WithInsecure()
+	}
+
+	exporter, err := otlpmetricgrpc.New(
+		a.ctx,
+		secureOption,
+		otlpmetricgrpc.WithEndpoint(a.config.Tracer.CollectorUrl),
+	)
+	if err != nil {
+		logger.Fatal("Failed to create metric exporter", zap.Error(err))
+	}
+
+	resources, err := a.otelResource()
+	if err != nil {
+		logger.Fatal("Could not set resources", zap.Error(err))
+	}
+
+	interval := a.config.Tracer.MetricInterval
+	if interval == 0 {
+		interval = defaultMetricInterval
+	}
+
+	provider := metric.NewMeterProvider(
+		metric.WithReader(metric.NewPeriodicReader(exporter, metric.WithInterval(time.Duration(interval)*time.Second))),
+		metric.WithResource(resources),
+	)
+	otel.SetMeterProvider(provider)
+
+	return provider
+}
@@ -10,7 +10,7 @@ import (
 )
 
 func (a *application) InitRouter(app *fiber.App, controller controllers.Controllers, redisClient producers.RedisClient, tracer oteltrace.Tracer) routers.Router {
-	router := routers.NewRouter(controller, redisClient, tracer)
+	router := routers.NewRouter(controller, redisClient, tracer, a.config.Server.Admin)
 	router.AddRoutes(app.Group(""))
 	return router
 }
@@ -3,8 +3,9 @@ package app
 import (
 	"mashaghel/internal/repositories"
 	"mashaghel/internal/services"
+	"mashaghel/internal/tasks"
 )
 
-func (a *application) InitServices(repository repositories.Repository) services.Service {
-	return services.NewService(repository)
+func (a *application) InitServices(repository repositories.Repository, task tasks.Task) services.Service {
+	return services.NewService(repository, task)
 }
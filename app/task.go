@@ -1,12 +1,19 @@
 package app
 
 import (
+	"mashaghel/internal/database/arango"
 	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/helper/nats"
+	"mashaghel/internal/producers"
 	"mashaghel/internal/tasks"
 
 	"go.uber.org/zap"
 )
 
-func (a *application) InitTask(scyllaDB scylla.ScyllaDB, logger *zap.Logger) tasks.Task {
-	return tasks.NewTaskManager(scyllaDB, logger, &a.config.WorkerPool)
+func (a *application) InitTask(scyllaDB scylla.ScyllaDB, arangoDB arango.ArangoDB, redis producers.RedisClient, connection nats.NatsConnection, logger *zap.Logger) tasks.Task {
+	task, err := tasks.NewTaskManager(scyllaDB, arangoDB, redis, connection, logger, &a.config.WorkerPool)
+	if err != nil {
+		logger.Fatal("Failed to create task manager", zap.Error(err))
+	}
+	return task
 }
@@ -9,6 +9,7 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	oteltrace "go.opentelemetry.io/otel/trace"
@@ -39,23 +40,32 @@ func (a *application) InitTracer() func(context.Context) error {
 		log.Fatalf("Failed to create exporter: %v", err)
 	}
 
-	resources, err := resource.New(
+	resources, err := a.otelResource()
+	if err != nil {
+		log.Fatalf("Could not set resources: %v", err)
+	}
+
+	provider := trace.NewTracerProvider(
+		trace.WithSampler(trace.AlwaysSample()),
+		trace.WithBatcher(exporter),
+		trace.WithResource(resources),
+	)
+	otel.SetTracerProvider(provider)
+	// Carries the trace context across the NATS events
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// Shutting down the provider flushes the batched spans and the exporter
+	return provider.Shutdown
+}
+
+// otelResource describes the service to the OTLP collector, traces and
+// metrics share it
+func (a *application) otelResource() (*resource.Resource, error) {
+	return resource.New(
 		a.ctx,
 		resource.WithAttributes(
 			attribute.String("service.name", a.config.Tracer.ServiceName),
 			attribute.String("library.language", "go"),
 		),
 	)
-	if err != nil {
-		log.Fatalf("Could not set resources: %v", err)
-	}
-
-	otel.SetTracerProvider(
-		trace.NewTracerProvider(
-			trace.WithSampler(trace.AlwaysSample()),
-			trace.WithBatcher(exporter),
-			trace.WithResource(resources),
-		))
-
-	return exporter.Shutdown
 }
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mashaghel/internal/config"
+	"mashaghel/internal/consumers"
+	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/helper/nats"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// tableSuffixPattern keeps the target suffix a valid part of a CQL table name
+var tableSuffixPattern = regexp.MustCompile(`^[a-zA-Z0-9_]*$`)
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Manage the events of the NATS streams",
+}
+
+// eventsReplayCmd represents the events replay command
+var eventsReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay the stored events of a stream into a consumer handler",
+	Long: `Replay the events a stream still stores into the handler of a consumer of
+nats.consumers, e.g. to rebuild its tables. The events are read in stream order
+through an ephemeral ordered consumer, the live consumers are not affected.
+--from is a stream sequence or an RFC 3339 time, the whole stream is replayed
+without it. --target appends a suffix to the tables the handler writes, so a
+rebuild can go to empty shadow tables with the schema of the live ones.
+Example:
+	events replay --stream UserInteraction --subject likes.change --handler likes --target _rebuild
+	events replay --stream UserInteraction --subject likes.change --handler likes --from 2026-10-01T00:00:00Z
+	events replay --stream UserInteraction --subject likes.change --handler likes --from 1200`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		streamFlag, err := cmd.Flags().GetString("stream")
+		if err != nil {
+			cmd.PrintErrf("Error while getting stream flag: %s", err.Error())
+			return
+		}
+		subjectFlag, err := cmd.Flags().GetString("subject")
+		if err != nil {
+			cmd.PrintErrf("Error while getting subject flag: %s", err.Error())
+			return
+		}
+		fromFlag, err := cmd.Flags().GetString("from")
+		if err != nil {
+			cmd.PrintErrf("Error while getting from flag: %s", err.Error())
+			return
+		}
+		handlerFlag, err := cmd.Flags().GetString("handler")
+		if err != nil {
+			cmd.PrintErrf("Error while getting handler flag: %s", err.Error())
+			return
+		}
+		targetFlag, err := cmd.Flags().GetString("target")
+		if err != nil {
+			cmd.PrintErrf("Error while getting target flag: %s", err.Error())
+			return
+		}
+		idleFlag, err := cmd.Flags().GetInt("idle_timeout")
+		if err != nil {
+			cmd.PrintErrf("Error while getting idle_timeout flag: %s", err.Error())
+			return
+		}
+
+		if streamFlag == "" || subjectFlag == "" || handlerFlag == "" {
+			cmd.PrintErr("--stream, --subject and --handler are required\n")
+			return
+		}
+		if !tableSuffixPattern.MatchString(targetFlag) {
+			cmd.PrintErrf("Invalid target %q, only letters, digits and _ are allowed\n", targetFlag)
+			return
+		}
+		opts := nats.ReplayOptions{
+			Stream:      streamFlag,
+			Subject:     subjectFlag,
+			IdleTimeout: time.Duration(idleFlag) * time.Second,
+		}
+		if err := parseReplayStart(fromFlag, &opts); err != nil {
+			cmd.PrintErrln(err)
+			return
+		}
+
+		cfg, err := config.LoadConfig("config/config.yml")
+		if err != nil {
+			log.Panicf("failed to setup viper: %s", err)
+			return
+		}
+
+		logger, err := zap.NewDevelopment()
+		if err != nil {
+			cmd.PrintErrf("failed to setup logger: %s", err)
+			return
+		}
+		defer logger.Sync()
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		db, err := scylla.NewScyllaDB(ctx, cfg, logger)
+		if err != nil {
+			cmd.PrintErrf("failed to setup scylla for replay: %s", err)
+			return
+		}
+		defer db.Close()
+
+		handler, ok := consumers.NewHandlers(db, targetFlag, logger)[handlerFlag]
+		if !ok {
+			cmd.PrintErrf("Unknown handler %s\n", handlerFlag)
+			return
+		}
+
+		connection, err := nats.NewNatsConnection(cfg.Nats, logger)
+		if err != nil {
+			cmd.PrintErrf("failed to setup nats for replay: %s", err)
+			return
+		}
+		defer connection.Close(context.Background())
+
+		result, err := connection.Replay(ctx, opts, handler)
+		cmd.Printf("Replayed %d events, skipped %d, last stream sequence %d\n", result.Handled, result.Skipped, result.LastSeq)
+		if err != nil {
+			cmd.PrintErrf("Replay stopped, resume with --from %d:\n\t %v\n", result.LastSeq+1, err)
+		}
+	},
+}
+
+// parseReplayStart sets the start of a replay from a stream sequence or an
+// RFC 3339 time
+func parseReplayStart(from string, opts *nats.ReplayOptions) error {
+	if from == "" {
+		return nil
+	}
+	if seq, err := strconv.ParseUint(from, 10, 64); err == nil {
+		opts.StartSeq = seq
+		return nil
+	}
+	startTime, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return fmt.Errorf("invalid from %q, expected a stream sequence or an RFC 3339 time", from)
+	}
+	opts.StartTime = startTime
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(eventsCmd)
+	eventsCmd.AddCommand(eventsReplayCmd)
+	eventsReplayCmd.Flags().String("stream", "", "Stream to replay")
+	eventsReplayCmd.Flags().String("subject", "", "Subject of the events to replay")
+	eventsReplayCmd.Flags().String("from", "", "Stream sequence or RFC 3339 time to replay from, the whole stream without it")
+	eventsReplayCmd.Flags().String("handler", "", "Consumer of nats.consumers whose handler the events are fed to")
+	eventsReplayCmd.Flags().String("target", "", "Suffix of the tables the handler writes to, e.g. _rebuild")
+	eventsReplayCmd.Flags().Int("idle_timeout", 5, "In seconds, the replay ends when no event arrived for that long")
+}
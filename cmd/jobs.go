@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/arango"
+	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/helper/nats"
+	"mashaghel/internal/producers"
+	"mashaghel/internal/tasks"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// jobsCmd represents the jobs command
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manage background jobs",
+}
+
+// jobsRunCmd represents the jobs run command
+var jobsRunCmd = &cobra.Command{
+	Use:   "run <name>",
+	Short: "Run a single execution of a background job and exit",
+	Long: `Run a single execution of a background job in this process. The job has to
+be enabled in worker_pool.tasks_config.jobs. Exclusive jobs still take the
+leader lock when it is enabled. With --dry-run the job writes nothing,
+checkpoints included, and prints a JSON report of what it would have written
+instead. Example:
+	jobs run watch                                    Move the idle watches now
+	jobs run watch --dry-run                          Print what the watch job would move
+	jobs run watch --dry-run --output report.json     Write the report to a file`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRunFlag, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			cmd.PrintErrf("Error while getting dry-run flag: %s", err.Error())
+			return
+		}
+		outputFlag, err := cmd.Flags().GetString("output")
+		if err != nil {
+			cmd.PrintErrf("Error while getting output flag: %s", err.Error())
+			return
+		}
+		if dryRunFlag {
+			dryRunJob(cmd, args[0], outputFlag)
+			return
+		}
+
+		err = withTaskManager(cmd, func(ctx context.Context, task tasks.Task) error {
+			return task.RunOnce(ctx, args[0])
+		})
+		if err != nil {
+			cmd.PrintErrf("Job %s failed:\n\t %v\n", args[0], err)
+			return
+		}
+		cmd.Printf("Job %s finished\n", args[0])
+	},
+}
+
+// dryRunJob prints the report of a dry run of a job, or writes it to output.
+// A run that failed part way still reports what it saw.
+func dryRunJob(cmd *cobra.Command, jobName string, output string) {
+	err := withTaskManager(cmd, func(ctx context.Context, task tasks.Task) error {
+		report, err := task.DryRun(ctx, jobName)
+		if report == nil {
+			return err
+		}
+		if err != nil {
+			cmd.PrintErrf("Dry run of %s finished with errors, the report is partial:\n\t %v\n", jobName, err)
+		}
+
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		if output == "" {
+			cmd.Println(string(content))
+			return nil
+		}
+		if err := os.WriteFile(output, content, 0o644); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		cmd.Printf("Report written to %s: %s\n", output, report)
+		return nil
+	})
+	if err != nil {
+		cmd.PrintErrf("Error while running dry run of %s:\n\t %v\n", jobName, err)
+	}
+}
+
+// jobsDeadLettersCmd represents the jobs dead_letters command
+var jobsDeadLettersCmd = &cobra.Command{
+	Use:   "dead_letters <name>",
+	Short: "List the work items of a job that failed every retry",
+	Long: `List the latest dead letters of a job as JSON. Example:
+	jobs dead_letters watch               List the latest dead letters of the watch job
+	jobs dead_letters watch --limit 100   List up to 100 dead letters`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		limitFlag, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			cmd.PrintErrf("Error while getting limit flag: %s", err.Error())
+			return
+		}
+
+		err = withTaskManager(cmd, func(ctx context.Context, task tasks.Task) error {
+			deadLetters, err := task.DeadLetters(ctx, args[0], limitFlag)
+			if err != nil {
+				return fmt.Errorf("failed to list dead letters: %w", err)
+			}
+
+			content, err := json.MarshalIndent(deadLetters, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to encode dead letters: %w", err)
+			}
+			cmd.Println(string(content))
+			return nil
+		})
+		if err != nil {
+			cmd.PrintErrf("Error while listing dead letters:\n\t %v\n", err)
+		}
+	},
+}
+
+// jobsCheckpointsCmd represents the jobs checkpoints command
+var jobsCheckpointsCmd = &cobra.Command{
+	Use:   "checkpoints <name>",
+	Short: "Show where the latest run of a job is in its token ring scan",
+	Long: `List the checkpoint of every token range of a job that scans the token ring
+as JSON: the last token processed, whether the range is finished and when the
+run started. Example:
+	jobs checkpoints watch               Show the scan position of the watch job`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := withTaskManager(cmd, func(ctx context.Context, task tasks.Task) error {
+			checkpoints, err := task.Checkpoints(ctx, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to load checkpoints: %w", err)
+			}
+
+			content, err := json.MarshalIndent(checkpoints, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to encode checkpoints: %w", err)
+			}
+			cmd.Println(string(content))
+			return nil
+		})
+		if err != nil {
+			cmd.PrintErrf("Error while listing checkpoints:\n\t %v\n", err)
+		}
+	},
+}
+
+// jobsReplayCmd represents the jobs replay command
+var jobsReplayCmd = &cobra.Command{
+	Use:   "replay <name>",
+	Short: "Replay the dead letters of a job",
+	Long: `Run dead-lettered work items of a job again, a replayed dead letter is removed.
+Example:
+	jobs replay watch --id <id>           Replay a single dead letter
+	jobs replay watch --all               Replay the latest dead letters, up to --limit`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		idFlag, err := cmd.Flags().GetString("id")
+		if err != nil {
+			cmd.PrintErrf("Error while getting id flag: %s", err.Error())
+			return
+		}
+		allFlag, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			cmd.PrintErrf("Error while getting all flag: %s", err.Error())
+			return
+		}
+		limitFlag, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			cmd.PrintErrf("Error while getting limit flag: %s", err.Error())
+			return
+		}
+		if (idFlag == "") == !allFlag {
+			cmd.PrintErr("Either --id or --all is required\n")
+			return
+		}
+
+		err = withTaskManager(cmd, func(ctx context.Context, task tasks.Task) error {
+			ids := []string{idFlag}
+			if allFlag {
+				deadLetters, err := task.DeadLetters(ctx, args[0], limitFlag)
+				if err != nil {
+					return fmt.Errorf("failed to list dead letters: %w", err)
+				}
+				ids = ids[:0]
+				for _, dl := range deadLetters {
+					ids = append(ids, dl.ID)
+				}
+			}
+
+			var failed int
+			for _, id := range ids {
+				if err := task.Replay(ctx, args[0], id); err != nil {
+					cmd.PrintErrf("Failed to replay %s: %s\n", id, err)
+					failed++
+					continue
+				}
+				cmd.Printf("Replayed %s\n", id)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d dead letters failed to replay", failed, len(ids))
+			}
+			return nil
+		})
+		if err != nil {
+			cmd.PrintErrf("Error while replaying dead letters:\n\t %v\n", err)
+		}
+	},
+}
+
+// withTaskManager runs fn with a task manager of the configured jobs that is
+// stopped when fn returns
+func withTaskManager(cmd *cobra.Command, fn func(ctx context.Context, task tasks.Task) error) error {
+	cfg, err := config.LoadConfig("config/config.yml")
+	if err != nil {
+		log.Panicf("failed to setup viper: %s", err)
+	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		return fmt.Errorf("failed to setup logger: %w", err)
+	}
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := scylla.NewScyllaDB(ctx, cfg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to setup scylla: %w", err)
+	}
+	defer db.Close()
+
+	arangoDB, err := arango.NewArangoDB(ctx, &cfg.ArangoDB)
+	if err != nil {
+		return fmt.Errorf("failed to setup arango: %w", err)
+	}
+
+	redis := producers.NewRedis(&cfg.Redis)
+	defer redis.Close()
+
+	// Only the outbox relay publishes, the other jobs run without NATS
+	var connection nats.NatsConnection
+	if job, ok := cfg.WorkerPool.TasksConfig.Jobs[tasks.OutboxRelayJobName]; ok && job.Enabled {
+		connection, err = nats.NewNatsConnection(cfg.Nats, logger)
+		if err != nil {
+			return fmt.Errorf("failed to setup nats: %w", err)
+		}
+		defer func() {
+			closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			connection.Close(closeCtx)
+		}()
+	}
+
+	task, err := tasks.NewTaskManager(db, arangoDB, redis, connection, logger, &cfg.WorkerPool)
+	if err != nil {
+		return fmt.Errorf("failed to create task manager: %w", err)
+	}
+	if err := task.InitWorkerPool(); err != nil {
+		return fmt.Errorf("failed to initialize worker pool: %w", err)
+	}
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		task.Stop(stopCtx)
+	}()
+
+	return fn(ctx, task)
+}
+
+func init() {
+	RootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsRunCmd)
+	jobsCmd.AddCommand(jobsDeadLettersCmd)
+	jobsCmd.AddCommand(jobsCheckpointsCmd)
+	jobsCmd.AddCommand(jobsReplayCmd)
+	jobsRunCmd.Flags().Bool("dry-run", false, "Report what the job would write without writing anything")
+	jobsRunCmd.Flags().String("output", "", "File the JSON report of --dry-run is written to, stdout when empty")
+	jobsDeadLettersCmd.Flags().Int("limit", 20, "Max dead letters to list")
+	jobsReplayCmd.Flags().String("id", "", "Id of the dead letter to replay")
+	jobsReplayCmd.Flags().Bool("all", false, "Replay the latest dead letters")
+	jobsReplayCmd.Flags().Int("limit", 100, "Max dead letters to replay with --all")
+}
@@ -0,0 +1,41 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"mashaghel/internal/database/scylla"
+
+	"github.com/spf13/cobra"
+)
+
+// scyllaMakemigrationCmd represents the sc_makemigration command
+var scyllaMakemigrationCmd = &cobra.Command{
+	Use:   "sc_makemigration [name]",
+	Short: "Create new up and down cql migration files",
+	Long: `sc_makemigration add_users_table // Create new up and down cql migration files
+		sc_makemigration add_users_table --dir ./internal/database/scylla/migrations // Create them in specific directory`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Println("sc_makemigration called")
+
+		dirFlag, err := cmd.Flags().GetString("dir")
+		if err != nil {
+			cmd.PrintErrf("Error while getting dir flag: %s", err.Error())
+			return
+		}
+
+		prefix, err := scylla.CreateMigrationFiles(dirFlag, args[0])
+		if err != nil {
+			cmd.PrintErrf("Error while creating migration files:\n\t %v", err)
+			return
+		}
+		cmd.Printf("Migration files %s.up.cql and %s.down.cql created successfully\n", prefix, prefix)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(scyllaMakemigrationCmd)
+
+	scyllaMakemigrationCmd.Flags().String("dir", "./internal/database/scylla/migrations", "Directory of scylla migrations")
+}
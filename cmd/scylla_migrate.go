@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"log"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/scylla"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// scyllaMigrateCmd represents the sc_migrate command
+var scyllaMigrateCmd = &cobra.Command{
+	Use:   "sc_migrate",
+	Short: "Migrate the cql files",
+	Long: `Apply the migrations that are not applied yet, in version order. Example:
+	sc_migrate                                              Apply all the migrations
+	sc_migrate --dir ./internal/database/scylla/migrations  Apply all the migrations of specific directory
+	sc_migrate --version 1792311000                         Apply the migrations up to and including this version`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Println("sc_migrate called")
+
+		dirFlag, err := cmd.Flags().GetString("dir")
+		if err != nil {
+			cmd.PrintErrf("Error while getting dir flag: %s", err.Error())
+			return
+		}
+
+		versionFlag, err := cmd.Flags().GetString("version")
+		if err != nil {
+			cmd.PrintErrf("Error while getting version flag: %s", err.Error())
+			return
+		}
+
+		dbConfig, err := config.LoadConfig("config/config.yml")
+		if err != nil {
+			log.Panicf("failed to setup viper: %s", err)
+			return
+		}
+
+		logger, err := zap.NewDevelopment()
+		if err != nil {
+			log.Panicf("failed to setup logger: %s", err)
+			return
+		}
+		defer logger.Sync()
+
+		ctx := cmd.Context()
+
+		db, err := scylla.NewScyllaDB(ctx, dbConfig, logger)
+		if err != nil {
+			cmd.PrintErrf("failed to setup scylla for migrations: %s", err)
+			return
+		}
+		defer db.Close()
+
+		migration := scylla.NewMigration(db, logger)
+		err = migration.Apply(ctx, dirFlag, versionFlag)
+		if err != nil {
+			cmd.PrintErrf("Error while applying migration:\n\t %v", err)
+			return
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(scyllaMigrateCmd)
+	scyllaMigrateCmd.Flags().String("dir", "./internal/database/scylla/migrations", "Directory of the migrations")
+	scyllaMigrateCmd.Flags().String("version", "", "Version of the last migration that is going to be applied")
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"log"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/scylla"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// scyllaRollbackCmd represents the sc_rollback command
+var scyllaRollbackCmd = &cobra.Command{
+	Use:   "sc_rollback",
+	Short: "Rollback migration/migrations",
+	Long: `Rollback the latest migration, or the migrations applied after a version.
+		For example:
+		sc_rollback
+		sc_rollback --dir ./internal/database/scylla/migrations --version 1792311000`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Println("sc_rollback called")
+
+		dirFlag, err := cmd.Flags().GetString("dir")
+		if err != nil {
+			cmd.PrintErrf("Error while getting dir flag: %s", err.Error())
+			return
+		}
+
+		versionFlag, err := cmd.Flags().GetString("version")
+		if err != nil {
+			cmd.PrintErrf("Error while getting version flag: %s", err.Error())
+			return
+		}
+
+		dbConfig, err := config.LoadConfig("config/config.yml")
+		if err != nil {
+			log.Panicf("failed to setup viper: %s", err)
+			return
+		}
+
+		logger, err := zap.NewDevelopment()
+		if err != nil {
+			log.Panicf("failed to setup logger: %s", err)
+			return
+		}
+		defer logger.Sync()
+
+		ctx := cmd.Context()
+
+		db, err := scylla.NewScyllaDB(ctx, dbConfig, logger)
+		if err != nil {
+			cmd.PrintErrf("failed to setup scylla for migrations: %s", err)
+			return
+		}
+		defer db.Close()
+
+		migration := scylla.NewMigration(db, logger)
+		err = migration.Rollback(ctx, dirFlag, versionFlag)
+		if err != nil {
+			cmd.PrintErrf("Error while rolling migration back:\n\t %v", err)
+			return
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(scyllaRollbackCmd)
+	scyllaRollbackCmd.Flags().String("dir", "./internal/database/scylla/migrations", "Directory of the migrations")
+	scyllaRollbackCmd.Flags().String("version", "", "Version of the migration that migrations will be rolled back to")
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"log"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/scylla"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// scyllaStatusCmd represents the sc_status command
+var scyllaStatusCmd = &cobra.Command{
+	Use:   "sc_status",
+	Short: "Show the state of the migrations",
+	Long: `List the migrations with their state: applied, pending, changed when the up
+file of an applied migration changed since, or missing when its files are gone.
+	sc_status
+	sc_status --dir ./internal/database/scylla/migrations`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Println("sc_status called")
+
+		dirFlag, err := cmd.Flags().GetString("dir")
+		if err != nil {
+			cmd.PrintErrf("Error while getting dir flag: %s", err.Error())
+			return
+		}
+
+		dbConfig, err := config.LoadConfig("config/config.yml")
+		if err != nil {
+			log.Panicf("failed to setup viper: %s", err)
+			return
+		}
+
+		logger, err := zap.NewDevelopment()
+		if err != nil {
+			log.Panicf("failed to setup logger: %s", err)
+			return
+		}
+		defer logger.Sync()
+
+		ctx := cmd.Context()
+
+		db, err := scylla.NewScyllaDB(ctx, dbConfig, logger)
+		if err != nil {
+			cmd.PrintErrf("failed to setup scylla for migrations: %s", err)
+			return
+		}
+		defer db.Close()
+
+		migration := scylla.NewMigration(db, logger)
+		statuses, err := migration.Status(ctx, dirFlag)
+		if err != nil {
+			cmd.PrintErrf("Error while getting migration status:\n\t %v", err)
+			return
+		}
+
+		for _, status := range statuses {
+			appliedAt := "-"
+			if !status.AppliedAt.IsZero() {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			cmd.Printf("%-8s %d_%s  %s\n", status.State, status.Version, status.Name, appliedAt)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(scyllaStatusCmd)
+	scyllaStatusCmd.Flags().String("dir", "./internal/database/scylla/migrations", "Directory of the migrations")
+}
@@ -9,20 +9,23 @@ import (
 type Controllers interface {
 	RpcServiceController() RpcServiceController
 	SystemController() SystemController
+	VideoController() VideoController
 }
 
 type controllers struct {
 	rpcServiceController RpcServiceController
 	systemController     SystemController
+	videoController      VideoController
 }
 
 func NewControllers(s services.Service, logger *zap.Logger) Controllers {
-	rpcServiceController := NewRpcServiceController(s.RpcServiceService(), logger)
+	rpcServiceController := NewRpcServiceController(s.RpcServiceService(), s.SystemService(), logger)
 	systemController := NewSystemController(s.SystemService(), logger)
 	return &controllers{
 
 		rpcServiceController: rpcServiceController,
 		systemController:     systemController,
+		videoController:      NewVideoController(s.VideoService(), logger),
 	}
 }
 
@@ -33,3 +36,7 @@ func (c *controllers) RpcServiceController() RpcServiceController {
 func (c *controllers) SystemController() SystemController {
 	return c.systemController
 }
+
+func (c *controllers) VideoController() VideoController {
+	return c.videoController
+}
@@ -2,25 +2,34 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	dto "mashaghel/handler/dtos"
 	"mashaghel/internal/services"
+	"mashaghel/internal/tasks"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	rpc_service "mashaghel/proto"
 )
 
 type RpcServiceController interface {
 	SayHello(ctx context.Context, req *rpc_service.HelloRequest) (*rpc_service.HelloReply, error)
+	RunJob(ctx context.Context, req *rpc_service.RunJobRequest) (*rpc_service.RunJobReply, error)
 }
 
 type rpcServiceController struct {
 	rpcServiceService services.RpcServiceService
+	systemService     services.SystemService
+	logger            *zap.Logger
 }
 
-func NewRpcServiceController(service services.RpcServiceService, logger *zap.Logger) RpcServiceController {
+func NewRpcServiceController(service services.RpcServiceService, systemService services.SystemService, logger *zap.Logger) RpcServiceController {
 	return &rpcServiceController{
 		rpcServiceService: service,
+		systemService:     systemService,
+		logger:            logger,
 	}
 }
 
@@ -34,3 +43,23 @@ func (c *rpcServiceController) SayHello(ctx context.Context, req *rpc_service.He
 
 	return responseDTO.ToHelloReply(), nil
 }
+
+func (c *rpcServiceController) RunJob(ctx context.Context, req *rpc_service.RunJobRequest) (*rpc_service.RunJobReply, error) {
+	requestDTO := dto.ToRunJobRequestDTO(req)
+
+	if err := c.systemService.TriggerJob(ctx, requestDTO.Name); err != nil {
+		c.logger.Error("Failed to trigger job", zap.Error(err), zap.String("job", requestDTO.Name))
+		switch {
+		case errors.Is(err, tasks.ErrJobNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, tasks.ErrJobRunning), errors.Is(err, tasks.ErrJobQueued):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		default:
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return &rpc_service.RunJobReply{
+		Message: "job run triggered",
+	}, nil
+}
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"time"
 
 	"mashaghel/internal/services"
+	"mashaghel/internal/tasks"
 
 	"go.uber.org/zap"
 
@@ -13,6 +15,8 @@ import (
 type SystemController interface {
 	HealthCheck(c *fiber.Ctx) error
 	ReadyCheck(c *fiber.Ctx) error
+	JobRuns(c *fiber.Ctx) error
+	TriggerJob(c *fiber.Ctx) error
 }
 
 type systemController struct {
@@ -47,3 +51,53 @@ func (controller *systemController) ReadyCheck(c *fiber.Ctx) error {
 		"time":       time.Now(),
 	})
 }
+
+func (controller *systemController) JobRuns(c *fiber.Ctx) error {
+	jobName := c.Params("name")
+	limit := c.QueryInt("limit", services.DefaultJobRunsLimit)
+
+	runs, err := controller.systemService.JobRuns(c.Context(), jobName, limit)
+	if err != nil {
+		controller.logger.Error("Failed to get job runs", zap.Error(err), zap.String("job", jobName))
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidJobRunsLimit) {
+			status = fiber.StatusBadRequest
+		}
+		message := err.Error()
+		var serviceErr *services.ServiceErr
+		if errors.As(err, &serviceErr) {
+			message = serviceErr.Message()
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"job":  jobName,
+		"runs": runs,
+	})
+}
+
+func (controller *systemController) TriggerJob(c *fiber.Ctx) error {
+	jobName := c.Params("name")
+
+	if err := controller.systemService.TriggerJob(c.Context(), jobName); err != nil {
+		controller.logger.Error("Failed to trigger job", zap.Error(err), zap.String("job", jobName))
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.Is(err, tasks.ErrJobNotFound):
+			status = fiber.StatusNotFound
+		case errors.Is(err, tasks.ErrJobRunning), errors.Is(err, tasks.ErrJobQueued):
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"job":    jobName,
+		"status": "triggered",
+	})
+}
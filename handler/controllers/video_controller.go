@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"errors"
+
+	"mashaghel/handler/presenters"
+	"mashaghel/internal/services"
+
+	"go.uber.org/zap"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type VideoController interface {
+	Trending(c *fiber.Ctx) error
+}
+
+type videoController struct {
+	videoService services.VideoService
+	logger       *zap.Logger
+}
+
+func NewVideoController(videoService services.VideoService, logger *zap.Logger) VideoController {
+	return &videoController{videoService: videoService, logger: logger}
+}
+
+func (controller *videoController) Trending(c *fiber.Ctx) error {
+	category := c.Query("category")
+	videoType := c.Query("type")
+	limit := c.QueryInt("limit", services.DefaultTrendingLimit)
+
+	videos, err := controller.videoService.Trending(c.Context(), category, videoType, limit)
+	if err != nil {
+		controller.logger.Error("Failed to get trending videos", zap.Error(err))
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidTrendingQuery) {
+			status = fiber.StatusBadRequest
+		}
+		message := err.Error()
+		var serviceErr *services.ServiceErr
+		if errors.As(err, &serviceErr) {
+			message = serviceErr.Message()
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	result := make([]interface{}, 0, len(videos))
+	for i := range videos {
+		result = append(result, presenters.NewTrendingVideoPresenter(&videos[i]).Present())
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"videos": result,
+	})
+}
@@ -19,6 +19,18 @@ func ToHelloRequestDTO(req *rpc_service.HelloRequest) *HelloRequestDTO {
 	}
 }
 
+// RunJobRequestDTO represents the domain model for run job request
+type RunJobRequestDTO struct {
+	Name string
+}
+
+// ToRunJobRequestDTO converts gRPC RunJobRequest to domain DTO
+func ToRunJobRequestDTO(req *rpc_service.RunJobRequest) *RunJobRequestDTO {
+	return &RunJobRequestDTO{
+		Name: req.Name,
+	}
+}
+
 // ToHelloReply converts domain DTO to gRPC HelloReply
 func (dto *HelloReplyDTO) ToHelloReply() *rpc_service.HelloReply {
 	return &rpc_service.HelloReply{
@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/subtle"
+	"slices"
+	"strings"
+
+	"mashaghel/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AdminMiddleware only lets through the requests that send the admin token
+// as a bearer token
+func AdminMiddleware(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !adminAuthorized(token, c.Get(fiber.HeaderAuthorization)) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// AdminUnaryInterceptor guards the given gRPC methods like AdminMiddleware
+// guards the admin routes, the token is read from the authorization metadata
+// of the call. The methods are refused while the admin endpoints are disabled.
+func AdminUnaryInterceptor(admin config.AdminConfig, methods ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !slices.Contains(methods, info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if !admin.Enabled {
+			return nil, status.Error(codes.PermissionDenied, "admin endpoints are disabled")
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		if !slices.ContainsFunc(md.Get("authorization"), func(header string) bool {
+			return adminAuthorized(admin.Token, header)
+		}) {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// adminAuthorized reports whether an authorization header carries token
+func adminAuthorized(token string, header string) bool {
+	got, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"mashaghel/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testAdminToken = "an-admin-token-of-at-least-32-characters"
+
+func TestAdminMiddleware(t *testing.T) {
+	app := fiber.New()
+	app.Get("/admin", AdminMiddleware(testAdminToken), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	testCases := map[string]int{
+		"":                               fiber.StatusUnauthorized,
+		testAdminToken:                   fiber.StatusUnauthorized,
+		"Bearer wrong":                   fiber.StatusUnauthorized,
+		"Bearer " + testAdminToken:       fiber.StatusOK,
+		"Bearer " + testAdminToken + "x": fiber.StatusUnauthorized,
+	}
+	for header, want := range testCases {
+		req := httptest.NewRequest(fiber.MethodGet, "/admin", nil)
+		if header != "" {
+			req.Header.Set(fiber.HeaderAuthorization, header)
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, want, resp.StatusCode, header)
+	}
+}
+
+func TestAdminUnaryInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	guarded := &grpc.UnaryServerInfo{FullMethod: "/svc/Guarded"}
+	open := &grpc.UnaryServerInfo{FullMethod: "/svc/Open"}
+	authorized := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+testAdminToken))
+
+	disabled := AdminUnaryInterceptor(config.AdminConfig{}, guarded.FullMethod)
+	_, err := disabled(authorized, nil, guarded, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = disabled(context.Background(), nil, open, handler)
+	assert.NoError(t, err)
+
+	enabled := AdminUnaryInterceptor(config.AdminConfig{Enabled: true, Token: testAdminToken}, guarded.FullMethod)
+	_, err = enabled(context.Background(), nil, guarded, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	resp, err := enabled(authorized, nil, guarded, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
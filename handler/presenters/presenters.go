@@ -2,4 +2,4 @@ package presenters
 
 type Presenter interface {
 	Present() interface{}
-}
\ No newline at end of file
+}
@@ -0,0 +1,19 @@
+package presenters
+
+import "mashaghel/internal/repositories/models"
+
+type trendingVideoPresenter struct {
+	*videoPresenter
+	Score float64 `json:"score"`
+}
+
+func NewTrendingVideoPresenter(video *models.TrendingVideo) Presenter {
+	return &trendingVideoPresenter{
+		videoPresenter: NewVideoPresenter(&video.Video).(*videoPresenter),
+		Score:          video.Score,
+	}
+}
+
+func (p *trendingVideoPresenter) Present() interface{} {
+	return p
+}
@@ -3,6 +3,7 @@ package routers
 import (
 	"mashaghel/handler/controllers"
 	"mashaghel/handler/middlewares"
+	"mashaghel/internal/config"
 	"mashaghel/internal/producers"
 
 	"github.com/gofiber/fiber/v2"
@@ -15,15 +16,18 @@ type Router interface {
 
 type router struct {
 	systemRouter SystemRouter
+	videoRouter  VideoRouter
 	redisClient  producers.RedisClient
 	tracer       trace.Tracer
 }
 
-func NewRouter(controllers controllers.Controllers, redisClient producers.RedisClient, tracer trace.Tracer) Router {
+func NewRouter(controllers controllers.Controllers, redisClient producers.RedisClient, tracer trace.Tracer, admin config.AdminConfig) Router {
 
 	return &router{
-		redisClient: redisClient,
-		tracer:      tracer,
+		systemRouter: NewSystemRouter(controllers.SystemController(), admin),
+		videoRouter:  NewVideoRouter(controllers.VideoController()),
+		redisClient:  redisClient,
+		tracer:       tracer,
 	}
 }
 
@@ -35,6 +39,7 @@ func (r router) AddRoutes(router fiber.Router) {
 	// CORS
 	router.Use(middlewares.TracingMiddleware(r.tracer))
 
-	// r.systemRouter.AddRoutes(router)
+	r.systemRouter.AddRoutes(router)
+	r.videoRouter.AddRoutes(router)
 
 }
@@ -2,6 +2,8 @@ package routers
 
 import (
 	"mashaghel/handler/controllers"
+	"mashaghel/handler/middlewares"
+	"mashaghel/internal/config"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,13 +14,21 @@ type SystemRouter interface {
 
 type systemRouter struct {
 	Controller controllers.SystemController
+	admin      config.AdminConfig
 }
 
-func NewSystemRouter(controller controllers.SystemController) SystemRouter {
-	return &systemRouter{Controller: controller}
+func NewSystemRouter(controller controllers.SystemController, admin config.AdminConfig) SystemRouter {
+	return &systemRouter{Controller: controller, admin: admin}
 }
 
 func (r *systemRouter) AddRoutes(router fiber.Router) {
 	router.Get("/api/health", r.Controller.HealthCheck)
 	router.Get("/health/ready", r.Controller.ReadyCheck)
+
+	if !r.admin.Enabled {
+		return
+	}
+	admin := router.Group("/admin", middlewares.AdminMiddleware(r.admin.Token))
+	admin.Get("/jobs/:name/runs", r.Controller.JobRuns)
+	admin.Post("/jobs/:name/runs", r.Controller.TriggerJob)
 }
@@ -0,0 +1,23 @@
+package routers
+
+import (
+	"mashaghel/handler/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type VideoRouter interface {
+	AddRoutes(router fiber.Router)
+}
+
+type videoRouter struct {
+	Controller controllers.VideoController
+}
+
+func NewVideoRouter(controller controllers.VideoController) VideoRouter {
+	return &videoRouter{Controller: controller}
+}
+
+func (r *videoRouter) AddRoutes(router fiber.Router) {
+	router.Get("/videos/trending", r.Controller.Trending)
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -31,6 +32,10 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := config.Nats.applyLegacyStream(); err != nil {
+		return nil, err
+	}
+
 	// Validate config values
 	validate := validator.New()
 	if err := validate.Struct(config); err != nil {
@@ -110,6 +115,29 @@ func GetArangoStrings(cfg *ArangoConfig) ([]string, error) {
 	return connections, nil
 }
 
+// legacyStreamSubjects are the subjects of a stream configured with
+// nats.stream_config, which had no subjects of its own
+var legacyStreamSubjects = []string{"likes.change", "views.recorded", "views.counted", "likes.applied", "watches.moved"}
+
+// applyLegacyStream turns the nats.stream_config of older configs into the
+// only stream of nats.streams
+func (c *NatsConfig) applyLegacyStream() error {
+	if c.LegacyStream == nil {
+		return nil
+	}
+	if len(c.Streams) > 0 {
+		return errors.New("nats.stream_config and nats.streams are both set, move the stream to nats.streams")
+	}
+
+	stream := *c.LegacyStream
+	if len(stream.Subjects) == 0 {
+		stream.Subjects = legacyStreamSubjects
+	}
+	c.Streams = []StreamConfig{stream}
+	c.LegacyStream = nil
+	return nil
+}
+
 func GetNatsURL(cfg *NatsConfig) string {
 	return fmt.Sprintf("nats://%s:%d/",
 		cfg.Host,
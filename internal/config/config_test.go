@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadConfig_legacyStream loads the default config with its streams
+// replaced by the nats.stream_config of older configs
+func TestLoadConfig_legacyStream(t *testing.T) {
+	content, err := os.ReadFile("../../config/config.yml")
+	require.NoError(t, err)
+
+	lines := strings.Split(string(content), "\n")
+	start := -1
+	for i, line := range lines {
+		if line == "  streams:" {
+			start = i
+			break
+		}
+	}
+	require.NotEqual(t, -1, start, "config.yml has no nats.streams")
+	end := start + 1
+	for end < len(lines) && (strings.HasPrefix(lines[end], "    ") || strings.TrimSpace(lines[end]) == "") {
+		end++
+	}
+	legacy := []string{
+		"  stream_config:",
+		"    name: UserInteraction",
+		"    retention: limits",
+		"    discard: old",
+		"    storage: file",
+		"    max_consumers: 2",
+		"    max_age: 2",
+	}
+	lines = append(lines[:start], append(legacy, lines[end:]...)...)
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644))
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Nats.Streams, 1)
+	assert.Equal(t, "UserInteraction", cfg.Nats.Streams[0].Name)
+	assert.Equal(t, legacyStreamSubjects, cfg.Nats.Streams[0].Subjects)
+	assert.Nil(t, cfg.Nats.LegacyStream)
+}
+
+func TestNatsConfig_applyLegacyStream(t *testing.T) {
+	legacy := &StreamConfig{Name: "Legacy", Subjects: []string{"a"}}
+
+	c := NatsConfig{LegacyStream: legacy}
+	require.NoError(t, c.applyLegacyStream())
+	assert.Equal(t, []StreamConfig{*legacy}, c.Streams)
+
+	c = NatsConfig{LegacyStream: legacy, Streams: []StreamConfig{{Name: "New"}}}
+	assert.Error(t, c.applyLegacyStream())
+
+	c = NatsConfig{Streams: []StreamConfig{{Name: "New"}}}
+	require.NoError(t, c.applyLegacyStream())
+	assert.Equal(t, "New", c.Streams[0].Name)
+}
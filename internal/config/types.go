@@ -20,11 +20,19 @@ type Config struct {
 
 // ServerConfig holds all server related configuration
 type ServerConfig struct {
-	Port         string `mapstructure:"port" validate:"required,number"`
-	Host         string `mapstructure:"host" validate:"required,hostname|ip"`
-	Mode         string `mapstructure:"mode" validate:"required,oneof=development production testing"`
-	ReadTimeout  int    `mapstructure:"read_timeout" validate:"required,min=1"`
-	WriteTimeout int    `mapstructure:"write_timeout" validate:"required,min=1"`
+	Port         string      `mapstructure:"port" validate:"required,number"`
+	Host         string      `mapstructure:"host" validate:"required,hostname|ip"`
+	Mode         string      `mapstructure:"mode" validate:"required,oneof=development production testing"`
+	ReadTimeout  int         `mapstructure:"read_timeout" validate:"required,min=1"`
+	WriteTimeout int         `mapstructure:"write_timeout" validate:"required,min=1"`
+	Admin        AdminConfig `mapstructure:"admin"`
+}
+
+// AdminConfig guards the endpoints that list and trigger job runs, they are
+// only served when enabled and to callers that send the token
+type AdminConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Token   string `mapstructure:"token" validate:"required_if=Enabled true,omitempty,min=32"` // Sent as "Authorization: Bearer <token>"
 }
 
 // RedisConfig holds all redis related configuration
@@ -86,6 +94,8 @@ type TracerConfig struct {
 	ServiceName  string `mapstructure:"service_name" validate:"required"`
 	CollectorUrl string `mapstructure:"collector_url" validate:"required"`
 	Insecure     string `mapstructure:"insecure" validate:"required"`
+	// In seconds, how often metrics are exported to the collector
+	MetricInterval int `mapstructure:"metric_interval" validate:"omitempty,min=1"`
 }
 type GRPCConfig struct {
 	Host string `mapstructure:"grpc_host" validate:"required,hostname|ip"` // gRPC server host
@@ -104,30 +114,154 @@ type ScyllaDBConfig struct {
 
 type WorkerPoolConfig struct {
 	WorkerPoolSize int         `mapstructure:"worker_pool_size" validate:"required,min=1"`
+	DrainTimeout   int         `mapstructure:"drain_timeout" validate:"omitempty,min=1"` // In seconds, how long stopping waits for running jobs
 	TasksConfig    TasksConfig `mapstructure:"tasks_config" validate:"required"`
 }
 
 type TasksConfig struct {
-	WatchCooldownDuration int `mapstructure:"watch_cooldown_duration" validate:"required,min=10"`
-	WatchAgeLimit         int `mapstructure:"watch_age_limit" validate:"required"`
+	WatchCooldownDuration int                    `mapstructure:"watch_cooldown_duration" validate:"required,min=10"`
+	WatchAgeLimit         int                    `mapstructure:"watch_age_limit" validate:"required"`
+	WatchBatch            BatchConfig            `mapstructure:"watch_batch"`
+	WatchRetention        WatchRetentionConfig   `mapstructure:"watch_retention"`
+	ContinueWatching      ContinueWatchingConfig `mapstructure:"continue_watching"`
+	ViewCounts            ViewCountsConfig       `mapstructure:"view_counts"`
+	Trending              TrendingConfig         `mapstructure:"trending"`
+	OutboxRelay           OutboxRelayConfig      `mapstructure:"outbox_relay"`
+	WatchLookup           string                 `mapstructure:"watch_lookup" validate:"omitempty,oneof=in per_key"` // How watched rows of a profile are fetched
+	CheckpointInterval    int                    `mapstructure:"checkpoint_interval" validate:"omitempty,min=1"`     // In seconds
+	ScanRanges            int                    `mapstructure:"scan_ranges" validate:"omitempty,min=1"`             // Token ring sub-ranges scanned concurrently
+	ScanPageSize          int                    `mapstructure:"scan_page_size" validate:"omitempty,min=1"`          // Rows fetched per page of a range scan
+	RunHistoryTTL         int                    `mapstructure:"run_history_ttl" validate:"omitempty,min=1"`         // In days, how long job runs are kept in job_runs
+	Jobs                  map[string]JobConfig   `mapstructure:"jobs" validate:"dive"`
+	LeaderLock            LeaderLockConfig       `mapstructure:"leader_lock"`
+}
+
+// WatchRetentionConfig limits the watch history kept in ordered_watch per
+// profile. An entry is dropped once it is past either limit.
+type WatchRetentionConfig struct {
+	MaxEntries int `mapstructure:"max_entries" validate:"omitempty,min=1"` // Newest entries kept per profile
+	MaxAge     int `mapstructure:"max_age" validate:"omitempty,min=1"`     // In days
+}
+
+// ContinueWatchingConfig configures the continue_watching row of a profile
+type ContinueWatchingConfig struct {
+	// Share of the video length past which an item counts as finished
+	CompletionThreshold float64 `mapstructure:"completion_threshold" validate:"omitempty,gt=0,lte=1"`
+	MaxItems            int     `mapstructure:"max_items" validate:"omitempty,min=1"` // Items kept per profile
+}
+
+// ViewCountsConfig configures which moved watches count as a view of their
+// video
+type ViewCountsConfig struct {
+	MinDuration int `mapstructure:"min_duration" validate:"min=0"` // In seconds
+}
+
+// OutboxRelayConfig configures how the outbox is relayed to NATS
+type OutboxRelayConfig struct {
+	BatchSize int `mapstructure:"batch_size" validate:"omitempty,min=1"` // Outbox rows read per shard at a time
+	Settle    int `mapstructure:"settle" validate:"omitempty,min=1"`     // In seconds, how old an event has to be before it is relayed
+}
+
+// TrendingConfig configures the time-decayed scores of the trending job
+type TrendingConfig struct {
+	Window     int     `mapstructure:"window" validate:"omitempty,min=1,max=168"` // In hours, how far back views and likes count, within the 7 days their logs are kept
+	HalfLife   int     `mapstructure:"half_life" validate:"omitempty,min=1"`      // In hours, age at which an event counts half
+	ViewWeight float64 `mapstructure:"view_weight" validate:"gte=0"`
+	LikeWeight float64 `mapstructure:"like_weight" validate:"gte=0"`
+	TopN       int     `mapstructure:"top_n" validate:"omitempty,min=1"` // Videos kept per category and type
+}
+
+// BatchConfig configures how background jobs split and write their batches
+type BatchConfig struct {
+	MaxStatements int    `mapstructure:"max_statements" validate:"omitempty,min=1"`                                                              // Statements per batch chunk
+	Consistency   string `mapstructure:"consistency" validate:"omitempty,oneof=any one two three quorum all local_quorum each_quorum local_one"` // Write consistency
+	Logged        bool   `mapstructure:"logged"`                                                                                                 // Use logged instead of unlogged single partition batches
+	Retries       int    `mapstructure:"retries" validate:"omitempty,min=0"`                                                                     // Retries of a failed chunk
+	RetryBackoff  int    `mapstructure:"retry_backoff" validate:"omitempty,min=0"`                                                               // In milliseconds, multiplied by the attempt
+}
+
+// LeaderLockConfig configures the redis lease a replica has to hold before it
+// runs an exclusive job
+type LeaderLockConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	KeyPrefix     string `mapstructure:"key_prefix"`
+	TTL           int    `mapstructure:"ttl" validate:"required_if=Enabled true,omitempty,min=1"` // In seconds
+	RenewInterval int    `mapstructure:"renew_interval" validate:"omitempty,min=1"`               // In seconds, defaults to a third of ttl
+}
+
+// JobConfig holds the settings of a single background job, keyed by job name
+type JobConfig struct {
+	Enabled      bool        `mapstructure:"enabled"`
+	Interval     int         `mapstructure:"interval" validate:"omitempty,min=1"`    // In seconds
+	Concurrency  int         `mapstructure:"concurrency" validate:"omitempty,min=1"` // Max in-flight work items on the worker pool
+	Schedule     string      `mapstructure:"schedule"`                               // Cron expression, takes precedence over interval
+	Timezone     string      `mapstructure:"timezone" validate:"omitempty,timezone"` // IANA time zone of the schedule
+	Jitter       int         `mapstructure:"jitter" validate:"omitempty,min=0"`      // In seconds
+	AllowOverlap bool        `mapstructure:"allow_overlap"`                          // Run even if the previous run is still in progress
+	Retry        RetryConfig `mapstructure:"retry"`                                  // Retry policy of the job's work items
+}
+
+// RetryConfig is the retry policy of the work items of a job. Items that
+// still fail after the last attempt are dead-lettered.
+type RetryConfig struct {
+	MaxAttempts    int     `mapstructure:"max_attempts" validate:"omitempty,min=1"`    // Attempts including the first one
+	InitialBackoff int     `mapstructure:"initial_backoff" validate:"omitempty,min=1"` // In milliseconds
+	MaxBackoff     int     `mapstructure:"max_backoff" validate:"omitempty,min=1"`     // In milliseconds
+	Multiplier     float64 `mapstructure:"multiplier" validate:"omitempty,min=1"`      // Growth of the backoff per attempt
+	Jitter         float64 `mapstructure:"jitter" validate:"omitempty,min=0,max=1"`    // Fraction of the backoff that is randomized
 }
 
 type NatsConfig struct {
-	ClientPort   int          `mapstructure:"client_port" validate:"required,min=1"`
-	ServerPort   int          `mapstructure:"server_port" validate:"required,min=1"`
-	Username     string       `mapstructure:"username" validate:"required"`
-	Password     string       `mapstructure:"password" validate:"required"`
-	Host         string       `mapstructure:"host" validate:"required"`
-	StreamConfig StreamConfig `mapstructure:"stream_config" validate:"required"`
-	Queue        string       `mapstructure:"queue" validate:"required"`
+	ClientPort int            `mapstructure:"client_port" validate:"required,min=1"`
+	ServerPort int            `mapstructure:"server_port" validate:"required,min=1"`
+	Username   string         `mapstructure:"username" validate:"required"`
+	Password   string         `mapstructure:"password" validate:"required"`
+	Host       string         `mapstructure:"host" validate:"required"`
+	Streams    []StreamConfig `mapstructure:"streams" validate:"required,min=1,dive"`
+	Queue      string         `mapstructure:"queue" validate:"required"`
+	// Encoding of the published events, json or protobuf
+	Encoding string        `mapstructure:"encoding" validate:"omitempty,oneof=json protobuf"`
+	Publish  PublishConfig `mapstructure:"publish"`
+	// Durable pull consumers, keyed by consumer name
+	Consumers map[string]ConsumerConfig `mapstructure:"consumers" validate:"dive"`
+	// LegacyStream is the single stream of configs written before streams,
+	// it is used as the only stream when streams is not set
+	LegacyStream *StreamConfig `mapstructure:"stream_config" validate:"-"`
+}
+
+// PublishConfig configures the async publishing to the stream
+type PublishConfig struct {
+	AckTimeout int `mapstructure:"ack_timeout" validate:"omitempty,min=1"` // In seconds, before an unacked publish fails
+	MaxPending int `mapstructure:"max_pending" validate:"omitempty,min=1"` // Unacked publishes before publishing stalls
+}
+
+// ConsumerConfig configures a durable pull consumer of the stream of its
+// subject
+type ConsumerConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	Subject    string `mapstructure:"subject" validate:"required"`
+	Durable    string `mapstructure:"durable"`                                // Defaults to the consumer name
+	BatchSize  int    `mapstructure:"batch_size" validate:"omitempty,min=1"`  // Messages fetched per pull request
+	FetchWait  int    `mapstructure:"fetch_wait" validate:"omitempty,min=1"`  // In seconds, how long a pull request waits for messages
+	AckWait    int    `mapstructure:"ack_wait" validate:"omitempty,min=1"`    // In seconds, before an unacked message is redelivered
+	MaxDeliver int    `mapstructure:"max_deliver" validate:"omitempty,min=1"` // Deliveries of a message before it is dropped
 }
 
+// StreamConfig configures a JetStream stream, the limits that are not set
+// are unlimited
 type StreamConfig struct {
-	NoAck        bool          `mapstructure:"no_ack"`
-	Name         string        `mapstructure:"name" validate:"required"`
-	Retention    string        `mapstructure:"retention" validate:"required,oneof=limits interest workqueue"`
-	Discard      string        `mapstructure:"discard" validate:"required,oneof=old new"`
-	Storage      string        `mapstructure:"storage" validate:"required,oneof=file memory"`
-	MaxConsumers int           `mapstructure:"max_consumers" validate:"required,min=1"`
-	MaxAge       time.Duration `mapstructure:"max_age" validate:"required,min=1"`
+	NoAck             bool          `mapstructure:"no_ack"`
+	Name              string        `mapstructure:"name" validate:"required"`
+	Subjects          []string      `mapstructure:"subjects" validate:"required,min=1"`
+	Retention         string        `mapstructure:"retention" validate:"required,oneof=limits interest workqueue"`
+	Discard           string        `mapstructure:"discard" validate:"required,oneof=old new"`
+	Storage           string        `mapstructure:"storage" validate:"required,oneof=file memory"`
+	Replicas          int           `mapstructure:"replicas" validate:"omitempty,min=1,max=5"`
+	MaxConsumers      int           `mapstructure:"max_consumers" validate:"omitempty,min=1"`
+	MaxMsgs           int64         `mapstructure:"max_msgs" validate:"omitempty,min=1"`
+	MaxBytes          int64         `mapstructure:"max_bytes" validate:"omitempty,min=1"`
+	MaxMsgSize        int32         `mapstructure:"max_msg_size" validate:"omitempty,min=1"`         // In bytes
+	MaxMsgsPerSubject int64         `mapstructure:"max_msgs_per_subject" validate:"omitempty,min=1"` // Messages kept of every subject
+	MaxAge            time.Duration `mapstructure:"max_age" validate:"required,min=1"`
+	Duplicates        time.Duration `mapstructure:"duplicates" validate:"omitempty,min=1"` // In minutes, the window of Nats-Msg-Id deduplication
 }
@@ -0,0 +1,17 @@
+package consumers
+
+import (
+	"mashaghel/internal/database/scylla"
+	natsHelper "mashaghel/internal/helper/nats"
+
+	"go.uber.org/zap"
+)
+
+// NewHandlers returns the handlers of the known consumers, keyed by their
+// name in nats.consumers. tableSuffix is appended to the tables they write,
+// the live consumers leave it empty.
+func NewHandlers(scyllaDB scylla.ScyllaDB, tableSuffix string, logger *zap.Logger) map[string]natsHelper.Handler {
+	return map[string]natsHelper.Handler{
+		LikesConsumerName: NewLikesConsumer(scyllaDB, tableSuffix, logger),
+	}
+}
@@ -0,0 +1,297 @@
+package consumers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"mashaghel/internal/database/scylla"
+	natsHelper "mashaghel/internal/helper/nats"
+	"mashaghel/internal/outbox"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+)
+
+const meterName = "mashaghel/internal/consumers"
+
+// LikesConsumerName is the name of the likes consumer in nats.consumers
+const LikesConsumerName = "likes"
+
+// LikeChangedEvent is the event type of a LikeChange
+const (
+	LikeChangedEvent        = "like.changed"
+	LikeChangedEventVersion = 1
+)
+
+// LikeAppliedEvent is the event type of a LikeChange that is stored,
+// published on LikeAppliedSubject through the outbox
+const (
+	LikeAppliedEvent        = "like.applied"
+	LikeAppliedEventVersion = 1
+	LikeAppliedSubject      = "likes.applied"
+)
+
+func init() {
+	natsHelper.RegisterEvent(LikeChangedEvent, LikeChangedEventVersion, func() interface{} {
+		return &LikeChange{}
+	})
+	natsHelper.RegisterEvent(LikeAppliedEvent, LikeAppliedEventVersion, func() interface{} {
+		return &LikeChange{}
+	})
+}
+
+// States of a profile towards a video
+const (
+	LikeStateLike    = "like"
+	LikeStateDislike = "dislike"
+	LikeStateNone    = "none"
+)
+
+// The likes are logged in like_log, a partition per shard and hour, for
+// trending to read the likes of its window
+const (
+	LikeLogShards     = 16
+	LikeLogBucketSize = time.Hour
+)
+
+// maxLikeStateAttempts caps the compare-and-set attempts of a change that
+// races with other changes of the same profile and video
+const maxLikeStateAttempts = 5
+
+// The table names are formatted with the table suffix of the consumer
+const (
+	querySelectProfileLike = `SELECT state, changed_at FROM profile_likes%s WHERE profile_id = ? AND play_id = ?;`
+	queryInsertProfileLike = `INSERT INTO profile_likes%s (profile_id, play_id, state, changed_at) VALUES (?, ?, ?, ?) IF NOT EXISTS;`
+	queryUpdateProfileLike = `UPDATE profile_likes%s SET state = ?, changed_at = ? WHERE profile_id = ? AND play_id = ? IF changed_at = ?;`
+	queryUpdateLikeCounts  = `UPDATE video_like_counts%s SET likes = likes + ?, dislikes = dislikes + ? WHERE play_id = ?;`
+	queryInsertLikeLog     = `INSERT INTO like_log%s (shard, bucket, id, profile_id, play_id) VALUES (?, ?, ?, ?, ?);`
+)
+
+var errLikeStateContended = errors.New("like state kept changing concurrently")
+
+// likeStateOutcome is what setState did with a change
+type likeStateOutcome int
+
+const (
+	likeStateApplied likeStateOutcome = iota
+	// likeStateStored is a change that is stored already, e.g. redelivered
+	likeStateStored
+	likeStateOutdated
+)
+
+// LikeChange is the payload of a like.changed event
+type LikeChange struct {
+	ProfileID string    `json:"profile_id"`
+	PlayID    string    `json:"play_id"`
+	State     string    `json:"state"` // like, dislike or none
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// likesConsumer keeps the like state of every profile in profile_likes and
+// the like and dislike counters of every video in video_like_counts
+type likesConsumer struct {
+	scylla  scylla.ScyllaDB
+	logger  *zap.Logger
+	queries likesQueries
+	// publish adds the like.applied events to the outbox, a rebuild into
+	// shadow tables publishes nothing
+	publish bool
+	// countFailures counts the changes whose counters were not updated
+	countFailures metric.Int64Counter
+}
+
+type likesQueries struct {
+	selectProfileLike string
+	insertProfileLike string
+	updateProfileLike string
+	updateLikeCounts  string
+	insertLikeLog     string
+}
+
+// NewLikesConsumer returns the handler of the likes consumer. It writes to
+// the likes tables with tableSuffix appended to their names, e.g. the shadow
+// tables of a rebuild, or to the tables themselves when it is empty.
+func NewLikesConsumer(scyllaDB scylla.ScyllaDB, tableSuffix string, logger *zap.Logger) natsHelper.Handler {
+	c := &likesConsumer{
+		scylla:  scyllaDB,
+		logger:  logger.With(zap.String("consumer", LikesConsumerName)),
+		publish: tableSuffix == "",
+		queries: likesQueries{
+			selectProfileLike: fmt.Sprintf(querySelectProfileLike, tableSuffix),
+			insertProfileLike: fmt.Sprintf(queryInsertProfileLike, tableSuffix),
+			updateProfileLike: fmt.Sprintf(queryUpdateProfileLike, tableSuffix),
+			updateLikeCounts:  fmt.Sprintf(queryUpdateLikeCounts, tableSuffix),
+			insertLikeLog:     fmt.Sprintf(queryInsertLikeLog, tableSuffix),
+		},
+	}
+
+	countFailures, err := otel.Meter(meterName).Int64Counter("consumers.likes.count_failures",
+		metric.WithDescription("Like changes stored without updating the like counters"),
+		metric.WithUnit("{change}"),
+	)
+	if err != nil {
+		c.logger.Error("Failed to create the like counter failures metric", zap.Error(err))
+		countFailures = noop.Int64Counter{}
+	}
+	c.countFailures = countFailures
+
+	return natsHelper.HandleEvents(c.Handle)
+}
+
+// Handle applies a like change. The state of a profile is only replaced by a
+// newer change, so redelivered and out of order messages change nothing.
+// The counters follow the state transition once it is stored, a crash in
+// between leaves them short rather than counting a change twice. A counter
+// update that fails is not retried, the redelivered change would find its
+// state stored, so it is counted in consumers.likes.count_failures and the
+// counters are repaired by a replay, see docs/nats.md#replay. The like_log
+// row and the like.applied event of the change are written last, and again
+// when the stored change is redelivered, so a failed write is retried with
+// the message.
+func (c *likesConsumer) Handle(ctx context.Context, event *natsHelper.Event) error {
+	change := *event.Payload.(*LikeChange)
+	profileID, err := gocql.ParseUUID(change.ProfileID)
+	if err != nil {
+		return natsHelper.Terminal(fmt.Errorf("invalid profile_id: %w", err))
+	}
+	playID, err := gocql.ParseUUID(change.PlayID)
+	if err != nil {
+		return natsHelper.Terminal(fmt.Errorf("invalid play_id: %w", err))
+	}
+	switch change.State {
+	case LikeStateLike, LikeStateDislike, LikeStateNone:
+	default:
+		return natsHelper.Terminal(fmt.Errorf("invalid state %q", change.State))
+	}
+	if change.ChangedAt.IsZero() {
+		return natsHelper.Terminal(errors.New("changed_at is required"))
+	}
+	// Stored with the millisecond precision of a CQL timestamp, a redelivered
+	// change has to compare equal to it
+	change.ChangedAt = change.ChangedAt.Truncate(time.Millisecond)
+
+	previous, outcome, err := c.setState(ctx, profileID, playID, change)
+	if err != nil {
+		return err
+	}
+	if outcome == likeStateOutdated {
+		c.logger.Debug("Skipping outdated like change",
+			zap.String("profileID", change.ProfileID),
+			zap.String("playID", change.PlayID),
+		)
+		return nil
+	}
+
+	if outcome == likeStateApplied {
+		likes, dislikes := likeCountDeltas(previous, change.State)
+		if likes != 0 || dislikes != 0 {
+			err := c.scylla.Session().Query(c.queries.updateLikeCounts, likes, dislikes, playID).WithContext(ctx).Exec()
+			if err != nil {
+				c.logger.Error("Failed to update like counts", zap.Error(err), zap.String("playID", change.PlayID))
+				c.countFailures.Add(ctx, 1)
+			}
+		}
+	}
+
+	// The state a redelivered change replaced is unknown, its like is logged
+	// again under the same key
+	logLike := change.State == LikeStateLike && (outcome == likeStateStored || previous != LikeStateLike)
+	return c.writeApplied(ctx, profileID, playID, change, logLike)
+}
+
+// writeApplied writes the like_log row and the like.applied event of a stored
+// change in a logged batch. The event ID is derived from the change, so the
+// event of a change written again is dropped by the stream as a duplicate.
+func (c *likesConsumer) writeApplied(ctx context.Context, profileID, playID gocql.UUID, change LikeChange, logLike bool) error {
+	batch := c.scylla.Session().NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	if logLike {
+		likedAt := change.ChangedAt.UTC()
+		batch.Query(c.queries.insertLikeLog, LikeLogShard(playID), likedAt.Truncate(LikeLogBucketSize), gocql.UUIDFromTime(likedAt), profileID, playID)
+	}
+	if c.publish {
+		event := natsHelper.NewEvent(ctx, LikeAppliedEvent, LikeAppliedEventVersion, &change)
+		event.ID = likeAppliedEventID(change)
+		if err := outbox.Add(batch, LikeAppliedSubject, change.PlayID, event); err != nil {
+			return err
+		}
+	}
+	if batch.Size() == 0 {
+		return nil
+	}
+	if err := c.scylla.Session().ExecuteBatch(batch); err != nil {
+		return fmt.Errorf("write applied like change: %w", err)
+	}
+	return nil
+}
+
+// likeAppliedEventID returns the ID of the like.applied event of a change
+func likeAppliedEventID(change LikeChange) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("profile_likes/"+change.ProfileID+"/"+change.PlayID+"/"+change.ChangedAt.UTC().Format(time.RFC3339Nano))).String()
+}
+
+// setState stores the state of a change unless it or a newer one is stored,
+// and returns the state it replaced
+func (c *likesConsumer) setState(ctx context.Context, profileID, playID gocql.UUID, change LikeChange) (string, likeStateOutcome, error) {
+	for range maxLikeStateAttempts {
+		var state string
+		var changedAt time.Time
+		err := c.scylla.Session().Query(c.queries.selectProfileLike, profileID, playID).
+			WithContext(ctx).
+			Scan(&state, &changedAt)
+
+		var query *gocql.Query
+		switch {
+		case errors.Is(err, gocql.ErrNotFound):
+			state = LikeStateNone
+			query = c.scylla.Session().Query(c.queries.insertProfileLike, profileID, playID, change.State, change.ChangedAt)
+		case err != nil:
+			return "", likeStateOutdated, err
+		case change.ChangedAt.Equal(changedAt) && change.State == state:
+			return state, likeStateStored, nil
+		case !change.ChangedAt.After(changedAt):
+			return state, likeStateOutdated, nil
+		default:
+			query = c.scylla.Session().Query(c.queries.updateProfileLike, change.State, change.ChangedAt, profileID, playID, changedAt)
+		}
+
+		applied, err := query.WithContext(ctx).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return "", likeStateOutdated, err
+		}
+		if applied {
+			return state, likeStateApplied, nil
+		}
+	}
+	return "", likeStateOutdated, errLikeStateContended
+}
+
+// LikeLogShard returns the like_log shard of the likes of a play
+func LikeLogShard(playID gocql.UUID) int {
+	h := fnv.New32a()
+	h.Write(playID.Bytes())
+	return int(h.Sum32() % LikeLogShards)
+}
+
+// likeCountDeltas returns how the like and dislike counters of a video change
+// when a profile goes from one state to another
+func likeCountDeltas(from, to string) (likes, dislikes int64) {
+	if from == LikeStateLike {
+		likes--
+	}
+	if from == LikeStateDislike {
+		dislikes--
+	}
+	if to == LikeStateLike {
+		likes++
+	}
+	if to == LikeStateDislike {
+		dislikes++
+	}
+	return likes, dislikes
+}
@@ -0,0 +1,76 @@
+package consumers
+
+import (
+	"context"
+	natsHelper "mashaghel/internal/helper/nats"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func Test_likeCountDeltas(t *testing.T) {
+	testCases := []struct {
+		from, to        string
+		likes, dislikes int64
+	}{
+		{from: LikeStateNone, to: LikeStateLike, likes: 1},
+		{from: LikeStateNone, to: LikeStateDislike, dislikes: 1},
+		{from: LikeStateLike, to: LikeStateDislike, likes: -1, dislikes: 1},
+		{from: LikeStateDislike, to: LikeStateNone, dislikes: -1},
+		{from: LikeStateLike, to: LikeStateLike},
+	}
+
+	for _, tt := range testCases {
+		likes, dislikes := likeCountDeltas(tt.from, tt.to)
+		if likes != tt.likes || dislikes != tt.dislikes {
+			t.Fatalf("likeCountDeltas(%s, %s) = %d, %d, want %d, %d", tt.from, tt.to, likes, dislikes, tt.likes, tt.dislikes)
+		}
+	}
+}
+
+func Test_likesConsumer_Handle_invalid(t *testing.T) {
+	handle := NewLikesConsumer(nil, "", zap.NewNop())
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "malformed", data: `{`},
+		{name: "invalid profile", data: `{"profile_id":"x","play_id":"7f1c2a44-8a7b-11ef-b864-0242ac120002","state":"like","changed_at":"2026-10-18T10:00:00Z"}`},
+		{name: "invalid state", data: `{"profile_id":"7f1c2a44-8a7b-11ef-b864-0242ac120002","play_id":"7f1c2a44-8a7b-11ef-b864-0242ac120002","state":"love","changed_at":"2026-10-18T10:00:00Z"}`},
+		{name: "missing changed_at", data: `{"profile_id":"7f1c2a44-8a7b-11ef-b864-0242ac120002","play_id":"7f1c2a44-8a7b-11ef-b864-0242ac120002","state":"like"}`},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			data := `{"id":"1","type":"like.changed","version":1,"occurred_at":"2026-10-18T10:00:00Z","payload":` + tt.data + `}`
+			err := handle(context.Background(), &nats.Msg{Data: []byte(data)})
+			if !natsHelper.IsTerminal(err) {
+				t.Fatalf("Handle() = %v, want a terminal error", err)
+			}
+		})
+	}
+}
+
+func Test_likeAppliedEventID(t *testing.T) {
+	change := LikeChange{
+		ProfileID: "2b0e5a5e-3f7c-4a4e-9a52-4c4d0b5b7b8e",
+		PlayID:    "7f1c2a44-8a7b-11ef-b864-0242ac120002",
+		State:     LikeStateLike,
+		ChangedAt: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+	}
+	// A redelivered change can carry changed_at in another zone
+	redelivered := change
+	redelivered.ChangedAt = change.ChangedAt.In(time.FixedZone("IRST", 12600))
+	if likeAppliedEventID(redelivered) != likeAppliedEventID(change) {
+		t.Fatal("likeAppliedEventID() differs for the same change")
+	}
+
+	later := change
+	later.ChangedAt = change.ChangedAt.Add(time.Millisecond)
+	if likeAppliedEventID(later) == likeAppliedEventID(change) {
+		t.Fatal("likeAppliedEventID() is the same for another change")
+	}
+}
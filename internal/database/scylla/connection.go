@@ -104,6 +104,10 @@ func NewScyllaDB(ctx context.Context, config *config.Config, logger *zap.Logger)
 	// Configure connection pooling based on expected load
 	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
 
+	// Queries and batches show up as events of the span of their context
+	cluster.QueryObserver = tracingObserver{}
+	cluster.BatchObserver = tracingObserver{}
+
 	session, err := cluster.CreateSession()
 	if err != nil {
 		logger.Error("Failed to create ScyllaDB session", zap.Error(err))
@@ -0,0 +1,489 @@
+package scylla
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.uber.org/zap"
+)
+
+const (
+	upMigrationSuffix   = ".up.cql"
+	downMigrationSuffix = ".down.cql"
+)
+
+const (
+	queryCreateMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT,
+		checksum TEXT,
+		applied_at TIMESTAMP
+	);`
+	querySelectMigrations = `SELECT version, name, checksum, applied_at FROM schema_migrations;`
+	queryInsertMigration  = `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?);`
+	queryDeleteMigration  = `DELETE FROM schema_migrations WHERE version = ?;`
+)
+
+// States of a migration in its MigrationStatus
+const (
+	MigrationApplied = "applied"
+	MigrationPending = "pending"
+	// MigrationChanged is an applied migration whose up file changed since
+	MigrationChanged = "changed"
+	// MigrationMissing is an applied migration whose files are gone
+	MigrationMissing = "missing"
+)
+
+// ErrIrreversibleMigration is returned when a rollback reaches a migration
+// whose down file has no statements, like the baseline migrations that took
+// over tables created by hand
+var ErrIrreversibleMigration = errors.New("migration is irreversible")
+
+// migrationNamePattern keeps the name of a migration a valid part of its file names
+var migrationNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// ScyllaMigration applies the versioned CQL migrations of a directory to the
+// keyspace and records them in its schema_migrations table
+type ScyllaMigration interface {
+	Apply(ctx context.Context, path string, version string) error
+	Rollback(ctx context.Context, path string, version string) error
+	Status(ctx context.Context, path string) ([]MigrationStatus, error)
+}
+
+// MigrationStatus is the state of a migration in the keyspace
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	State     string
+	AppliedAt time.Time
+}
+
+type scyllaMigration struct {
+	session *gocql.Session
+	logger  *zap.Logger
+}
+
+// migrationFile is a migration read from its up and down files
+type migrationFile struct {
+	Version  int64
+	Name     string
+	Up       []string
+	Down     []string
+	Checksum string
+}
+
+// migrationRecord is a row of schema_migrations
+type migrationRecord struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// NewMigration returns the migrations of the keyspace of the session
+func NewMigration(db ScyllaDB, logger *zap.Logger) ScyllaMigration {
+	return &scyllaMigration{
+		session: db.Session(),
+		logger:  logger,
+	}
+}
+
+// CreateMigrationFiles creates the empty up and down files of a new
+// migration in path and returns their common prefix, e.g. 1792311000_add_users
+func CreateMigrationFiles(path string, name string) (string, error) {
+	if !migrationNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid migration name %q, only letters, digits and _ are allowed", name)
+	}
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	prefix := fmt.Sprintf("%d_%s", time.Now().Unix(), name)
+	templates := map[string]string{
+		upMigrationSuffix:   "-- Statements applying " + name + ", separated by ;\n",
+		downMigrationSuffix: "-- Statements reverting " + name + ", separated by ;\n-- Without statements the migration can not be rolled back\n",
+	}
+	for suffix, template := range templates {
+		if err := os.WriteFile(filepath.Join(path, prefix+suffix), []byte(template), 0o644); err != nil {
+			return "", err
+		}
+	}
+	return prefix, nil
+}
+
+// Apply applies the migrations of path that are not applied yet, in version
+// order, up to and including version when it is set. It refuses to apply
+// anything when an applied migration changed since.
+func (s *scyllaMigration) Apply(ctx context.Context, path string, version string) error {
+	files, records, err := s.load(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksums(files, records); err != nil {
+		return err
+	}
+
+	target := int64(-1)
+	if version != "" {
+		target, err = parseVersion(version)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(files, func(f migrationFile) bool { return f.Version == target }) {
+			return fmt.Errorf("no migration with version %d found in %s", target, path)
+		}
+	}
+
+	applied := make(map[int64]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	var count int
+	for _, file := range files {
+		if target >= 0 && file.Version > target {
+			break
+		}
+		if applied[file.Version] {
+			continue
+		}
+
+		s.logger.Info("Applying migration", zap.Int64("version", file.Version), zap.String("name", file.Name))
+		if err := s.exec(ctx, file, file.Up); err != nil {
+			return err
+		}
+		err := s.session.Query(queryInsertMigration, file.Version, file.Name, file.Checksum, time.Now().UTC()).WithContext(ctx).Exec()
+		if err != nil {
+			return fmt.Errorf("failed to record migration %d_%s: %w", file.Version, file.Name, err)
+		}
+		count++
+	}
+
+	s.logger.Info("Migrations applied successfully", zap.Int("applied", count))
+	return nil
+}
+
+// Rollback reverts the latest applied migration, or every migration applied
+// after version when it is set, newest first. It rolls back nothing when
+// one of them is irreversible.
+func (s *scyllaMigration) Rollback(ctx context.Context, path string, version string) error {
+	files, records, err := s.load(ctx, path)
+	if err != nil {
+		return err
+	}
+	reverts, err := rollbackPlan(files, records, version)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reverts {
+		s.logger.Info("Rolling back migration", zap.Int64("version", file.Version), zap.String("name", file.Name))
+		if err := s.exec(ctx, file, file.Down); err != nil {
+			return err
+		}
+		if err := s.session.Query(queryDeleteMigration, file.Version).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("failed to delete record of migration %d_%s: %w", file.Version, file.Name, err)
+		}
+	}
+
+	s.logger.Info("Migrations rolled back successfully", zap.Int("rolledBack", len(reverts)))
+	return nil
+}
+
+// rollbackPlan returns the migrations a rollback to version reverts, newest
+// first. Every one of them is checked before the first one is rolled back,
+// so an irreversible migration does not stop the rollback halfway.
+func rollbackPlan(files []migrationFile, records []migrationRecord, version string) ([]migrationFile, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no applied migrations to roll back")
+	}
+
+	rollback := records[len(records)-1:]
+	if version != "" {
+		target, err := parseVersion(version)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(records, func(r migrationRecord) bool { return r.Version == target })
+		if i < 0 {
+			return nil, fmt.Errorf("no applied migration with version %d found", target)
+		}
+		rollback = records[i+1:]
+	}
+
+	reverts := make([]migrationFile, 0, len(rollback))
+	for i := len(rollback) - 1; i >= 0; i-- {
+		record := rollback[i]
+		j := slices.IndexFunc(files, func(f migrationFile) bool { return f.Version == record.Version })
+		if j < 0 {
+			return nil, fmt.Errorf("files of applied migration %d_%s not found", record.Version, record.Name)
+		}
+		file := files[j]
+		if file.Checksum != record.Checksum {
+			return nil, fmt.Errorf("migration %d_%s changed after it was applied, its down file may not revert it", file.Version, file.Name)
+		}
+		if len(file.Down) == 0 {
+			return nil, fmt.Errorf("%w: %d_%s has no down statements, roll back to %d at the earliest", ErrIrreversibleMigration, file.Version, file.Name, file.Version)
+		}
+		reverts = append(reverts, file)
+	}
+	return reverts, nil
+}
+
+// Status returns the state of every migration of path and of every applied
+// one, in version order
+func (s *scyllaMigration) Status(ctx context.Context, path string) ([]MigrationStatus, error) {
+	files, records, err := s.load(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return migrationStatuses(files, records), nil
+}
+
+// load reads the migrations of path and the applied ones, creating the
+// schema_migrations table when it does not exist
+func (s *scyllaMigration) load(ctx context.Context, path string) ([]migrationFile, []migrationRecord, error) {
+	files, err := readMigrations(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.session.Query(queryCreateMigrationsTable).WithContext(ctx).Exec(); err != nil {
+		s.logger.Error("Failed to create schema_migrations table", zap.Error(err))
+		return nil, nil, err
+	}
+	if err := s.session.AwaitSchemaAgreement(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	iter := s.session.Query(querySelectMigrations).WithContext(ctx).Iter()
+	var records []migrationRecord
+	var record migrationRecord
+	for iter.Scan(&record.Version, &record.Name, &record.Checksum, &record.AppliedAt) {
+		records = append(records, record)
+	}
+	if err := iter.Close(); err != nil {
+		s.logger.Error("Failed to read schema_migrations table", zap.Error(err))
+		return nil, nil, err
+	}
+	slices.SortFunc(records, func(a, b migrationRecord) int { return cmp.Compare(a.Version, b.Version) })
+
+	return files, records, nil
+}
+
+// exec runs the statements of a migration one at a time and waits for the
+// nodes to agree on the schema after each of them. A statement that fails
+// leaves the ones before it applied, which is why they should be idempotent.
+func (s *scyllaMigration) exec(ctx context.Context, file migrationFile, statements []string) error {
+	for i, statement := range statements {
+		if err := s.session.Query(statement).WithContext(ctx).Exec(); err != nil {
+			s.logger.Error("Failed to execute migration statement", zap.Error(err), zap.Int64("version", file.Version), zap.Int("statement", i+1))
+			return fmt.Errorf("migration %d_%s, statement %d: %w", file.Version, file.Name, i+1, err)
+		}
+		if err := s.session.AwaitSchemaAgreement(ctx); err != nil {
+			return fmt.Errorf("migration %d_%s, statement %d: %w", file.Version, file.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// readMigrations reads the migrations of path in version order. Every
+// migration needs both an up and a down file.
+func readMigrations(path string) ([]migrationFile, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	migrations := make(map[int64]*migrationFile)
+	downs := make(map[int64]bool)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var prefix string
+		var up bool
+		switch {
+		case entry.IsDir():
+			continue
+		case strings.HasSuffix(fileName, upMigrationSuffix):
+			prefix, up = strings.TrimSuffix(fileName, upMigrationSuffix), true
+		case strings.HasSuffix(fileName, downMigrationSuffix):
+			prefix = strings.TrimSuffix(fileName, downMigrationSuffix)
+		default:
+			continue
+		}
+
+		versionPart, name, ok := strings.Cut(prefix, "_")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid migration file name %s, expected <version>_<name>%s", fileName, upMigrationSuffix)
+		}
+		version, err := parseVersion(versionPart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s: %w", fileName, err)
+		}
+		content, err := os.ReadFile(filepath.Join(path, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := migrations[version]
+		if !ok {
+			migration = &migrationFile{Version: version, Name: name}
+			migrations[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migrations %d_%s and %d_%s have the same version", version, migration.Name, version, name)
+		}
+		if up {
+			migration.Up = splitStatements(string(content))
+			migration.Checksum = checksum(migration.Up)
+		} else {
+			migration.Down = splitStatements(string(content))
+			downs[version] = true
+		}
+	}
+
+	files := make([]migrationFile, 0, len(migrations))
+	for _, migration := range migrations {
+		if migration.Checksum == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		if !downs[migration.Version] {
+			return nil, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		files = append(files, *migration)
+	}
+	slices.SortFunc(files, func(a, b migrationFile) int { return cmp.Compare(a.Version, b.Version) })
+	return files, nil
+}
+
+// verifyChecksums fails when an applied migration changed since
+func verifyChecksums(files []migrationFile, records []migrationRecord) error {
+	for _, status := range migrationStatuses(files, records) {
+		if status.State == MigrationChanged {
+			return fmt.Errorf("migration %d_%s changed after it was applied, add a new migration instead", status.Version, status.Name)
+		}
+	}
+	return nil
+}
+
+// migrationStatuses merges the migrations of a directory with the applied ones
+func migrationStatuses(files []migrationFile, records []migrationRecord) []MigrationStatus {
+	byVersion := make(map[int64]migrationRecord, len(records))
+	for _, record := range records {
+		byVersion[record.Version] = record
+	}
+
+	statuses := make([]MigrationStatus, 0, len(files))
+	for _, file := range files {
+		status := MigrationStatus{Version: file.Version, Name: file.Name, State: MigrationPending}
+		if record, ok := byVersion[file.Version]; ok {
+			status.State = MigrationApplied
+			if record.Checksum != file.Checksum {
+				status.State = MigrationChanged
+			}
+			status.AppliedAt = record.AppliedAt
+			delete(byVersion, file.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range byVersion {
+		statuses = append(statuses, MigrationStatus{
+			Version:   record.Version,
+			Name:      record.Name,
+			State:     MigrationMissing,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int { return cmp.Compare(a.Version, b.Version) })
+	return statuses
+}
+
+// checksum identifies the statements of a migration. Comments and
+// whitespace around the statements do not change it.
+func checksum(statements []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(statements, ";\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitStatements splits CQL into its statements on the ; outside of
+// strings and drops the comments
+func splitStatements(cql string) []string {
+	var statements []string
+	var current strings.Builder
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(cql); i++ {
+		rest := cql[i:]
+		switch {
+		case rest[0] == '\'' || rest[0] == '"':
+			// A quote is escaped by doubling it
+			end := 1
+			for end < len(rest) {
+				if rest[end] == rest[0] {
+					if end+1 < len(rest) && rest[end+1] == rest[0] {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			end = min(end+1, len(rest))
+			current.WriteString(rest[:end])
+			i += end - 1
+		case strings.HasPrefix(rest, "$$"):
+			end := len(rest)
+			if j := strings.Index(rest[2:], "$$"); j >= 0 {
+				end = j + 4
+			}
+			current.WriteString(rest[:end])
+			i += end - 1
+		case strings.HasPrefix(rest, "--") || strings.HasPrefix(rest, "//"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			current.WriteByte('\n')
+			i += end
+		case strings.HasPrefix(rest, "/*"):
+			end := len(rest)
+			if j := strings.Index(rest[2:], "*/"); j >= 0 {
+				end = j + 4
+			}
+			current.WriteByte(' ')
+			i += end - 1
+		case rest[0] == ';':
+			flush()
+		default:
+			current.WriteByte(rest[0])
+		}
+	}
+	flush()
+
+	return statements
+}
+
+func parseVersion(version string) (int64, error) {
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid migration version %q, expected a unix timestamp", version)
+	}
+	return v, nil
+}
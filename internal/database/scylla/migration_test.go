@@ -0,0 +1,195 @@
+package scylla
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		cql  string
+		want []string
+	}{
+		{
+			name: "statements and comments",
+			cql: `-- header
+CREATE TABLE a (id INT PRIMARY KEY); // trailing
+/* block; comment */ DROP TABLE b;
+-- footer`,
+			want: []string{"CREATE TABLE a (id INT PRIMARY KEY)", "DROP TABLE b"},
+		},
+		{
+			name: "semicolons and comment markers in strings",
+			cql:  `INSERT INTO a (id, v) VALUES (1, 'it''s; -- not a comment'); ALTER TABLE "we;ird" ADD c TEXT`,
+			want: []string{`INSERT INTO a (id, v) VALUES (1, 'it''s; -- not a comment')`, `ALTER TABLE "we;ird" ADD c TEXT`},
+		},
+		{
+			name: "dollar quoted string",
+			cql:  `INSERT INTO a (id, v) VALUES (1, $$a;b$$);`,
+			want: []string{`INSERT INTO a (id, v) VALUES (1, $$a;b$$)`},
+		},
+		{
+			name: "only comments",
+			cql:  "-- nothing to do\n;\n",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitStatements(tt.cql))
+		})
+	}
+}
+
+func TestReadMigrations(t *testing.T) {
+	write := func(t *testing.T, dir string, files map[string]string) {
+		for name, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+		}
+	}
+
+	t.Run("in version order", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, map[string]string{
+			"20_second.up.cql":   "CREATE TABLE b (id INT PRIMARY KEY);",
+			"20_second.down.cql": "DROP TABLE b;",
+			"10_first.up.cql":    "CREATE TABLE a (id INT PRIMARY KEY);",
+			"10_first.down.cql":  "DROP TABLE a;",
+			"README.md":          "not a migration",
+		})
+
+		files, err := readMigrations(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		assert.Equal(t, int64(10), files[0].Version)
+		assert.Equal(t, "first", files[0].Name)
+		assert.Equal(t, []string{"DROP TABLE a"}, files[0].Down)
+		assert.Equal(t, int64(20), files[1].Version)
+	})
+
+	t.Run("checksum ignores comments", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, map[string]string{
+			"10_first.up.cql":   "CREATE TABLE a (id INT PRIMARY KEY);",
+			"10_first.down.cql": "",
+		})
+		before, err := readMigrations(dir)
+		require.NoError(t, err)
+
+		write(t, dir, map[string]string{"10_first.up.cql": "-- documented\nCREATE TABLE a (id INT PRIMARY KEY);\n"})
+		after, err := readMigrations(dir)
+		require.NoError(t, err)
+		assert.Equal(t, before[0].Checksum, after[0].Checksum)
+
+		write(t, dir, map[string]string{"10_first.up.cql": "CREATE TABLE a (id BIGINT PRIMARY KEY);"})
+		changed, err := readMigrations(dir)
+		require.NoError(t, err)
+		assert.NotEqual(t, before[0].Checksum, changed[0].Checksum)
+	})
+
+	for name, files := range map[string]map[string]string{
+		"missing down file": {"10_first.up.cql": "CREATE TABLE a (id INT PRIMARY KEY);"},
+		"missing up file":   {"10_first.down.cql": "DROP TABLE a;"},
+		"invalid version":   {"first.up.cql": "", "first.down.cql": ""},
+		"duplicate version": {
+			"10_first.up.cql": "", "10_first.down.cql": "",
+			"10_other.up.cql": "", "10_other.down.cql": "",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			write(t, dir, files)
+			_, err := readMigrations(dir)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMigrationStatuses(t *testing.T) {
+	appliedAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	files := []migrationFile{
+		{Version: 10, Name: "first", Checksum: "a"},
+		{Version: 20, Name: "second", Checksum: "b"},
+		{Version: 30, Name: "third", Checksum: "c"},
+	}
+	records := []migrationRecord{
+		{Version: 10, Name: "first", Checksum: "a", AppliedAt: appliedAt},
+		{Version: 15, Name: "deleted", Checksum: "x", AppliedAt: appliedAt},
+		{Version: 20, Name: "second", Checksum: "changed", AppliedAt: appliedAt},
+	}
+
+	assert.Equal(t, []MigrationStatus{
+		{Version: 10, Name: "first", State: MigrationApplied, AppliedAt: appliedAt},
+		{Version: 15, Name: "deleted", State: MigrationMissing, AppliedAt: appliedAt},
+		{Version: 20, Name: "second", State: MigrationChanged, AppliedAt: appliedAt},
+		{Version: 30, Name: "third", State: MigrationPending},
+	}, migrationStatuses(files, records))
+
+	assert.Error(t, verifyChecksums(files, records))
+	assert.NoError(t, verifyChecksums(files, records[:2]))
+}
+
+func TestRollbackPlan(t *testing.T) {
+	files := []migrationFile{
+		{Version: 10, Name: "baseline", Checksum: "a"},
+		{Version: 20, Name: "second", Checksum: "b", Down: []string{"DROP TABLE b"}},
+		{Version: 30, Name: "third", Checksum: "c", Down: []string{"DROP TABLE c"}},
+	}
+	records := []migrationRecord{
+		{Version: 10, Name: "baseline", Checksum: "a"},
+		{Version: 20, Name: "second", Checksum: "b"},
+		{Version: 30, Name: "third", Checksum: "c"},
+	}
+	versions := func(files []migrationFile) []int64 {
+		var v []int64
+		for _, file := range files {
+			v = append(v, file.Version)
+		}
+		return v
+	}
+
+	plan, err := rollbackPlan(files, records, "")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{30}, versions(plan))
+
+	plan, err = rollbackPlan(files, records, "10")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{30, 20}, versions(plan))
+
+	_, err = rollbackPlan(files, records[:1], "")
+	assert.ErrorIs(t, err, ErrIrreversibleMigration)
+
+	_, err = rollbackPlan(files, nil, "")
+	assert.Error(t, err)
+
+	_, err = rollbackPlan(files, records, "15")
+	assert.Error(t, err)
+}
+
+func TestMigrationsDirectory(t *testing.T) {
+	files, err := readMigrations("migrations")
+	require.NoError(t, err)
+	for _, file := range files {
+		assert.NotEmpty(t, file.Up, "%d_%s", file.Version, file.Name)
+	}
+
+	// The baseline migration took over tables created by hand and must never
+	// drop them, every later one created its tables and can drop them again
+	records := make([]migrationRecord, 0, len(files))
+	for _, file := range files {
+		records = append(records, migrationRecord{Version: file.Version, Name: file.Name, Checksum: file.Checksum})
+	}
+	_, err = rollbackPlan(files, records[:1], "")
+	assert.ErrorIs(t, err, ErrIrreversibleMigration)
+	reverts, err := rollbackPlan(files, records, strconv.FormatInt(records[0].Version, 10))
+	require.NoError(t, err)
+	assert.Len(t, reverts, len(files)-1)
+}
@@ -0,0 +1,57 @@
+package scylla
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingObserver adds every query and batch attempt as an event to the span
+// of the context the statement was executed with
+type tracingObserver struct{}
+
+func (tracingObserver) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("db.statement", q.Statement),
+		attribute.Int("db.rows", q.Rows),
+		attribute.Int("db.attempt", q.Attempt),
+		attribute.Int64("db.latency_us", q.End.Sub(q.Start).Microseconds()),
+	}
+	addHost(&attrs, q.Host)
+	if q.Err != nil {
+		attrs = append(attrs, attribute.String("error", q.Err.Error()))
+	}
+	span.AddEvent("scylla.query", trace.WithTimestamp(q.End), trace.WithAttributes(attrs...))
+}
+
+func (tracingObserver) ObserveBatch(ctx context.Context, b gocql.ObservedBatch) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.Int("db.statements", len(b.Statements)),
+		attribute.Int("db.attempt", b.Attempt),
+		attribute.Int64("db.latency_us", b.End.Sub(b.Start).Microseconds()),
+	}
+	addHost(&attrs, b.Host)
+	if b.Err != nil {
+		attrs = append(attrs, attribute.String("error", b.Err.Error()))
+	}
+	span.AddEvent("scylla.batch", trace.WithTimestamp(b.End), trace.WithAttributes(attrs...))
+}
+
+func addHost(attrs *[]attribute.KeyValue, host *gocql.HostInfo) {
+	if host == nil {
+		return
+	}
+	*attrs = append(*attrs, attribute.String("db.host", host.ConnectAddress().String()))
+}
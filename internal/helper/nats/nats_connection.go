@@ -1,7 +1,9 @@
 package nats
 
 import (
+	"context"
 	"mashaghel/internal/config"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -9,14 +11,35 @@ import (
 )
 
 type NatsConnection interface {
-	Close()
-	Publish(subject string, message []byte) error
+	// Close waits for the pending publish acks until ctx is done and closes
+	// the connection
+	Close(ctx context.Context) error
+	// Publish publishes message with a Nats-Msg-Id of msgID, a new one when
+	// empty. A retry of a message has to reuse its ID to be deduplicated.
+	Publish(subject, msgID string, message []byte) (*Publication, error)
+	// PublishEvent validates an event and publishes it in the configured
+	// encoding, with the event ID as Nats-Msg-Id
+	PublishEvent(ctx context.Context, subject string, event *Event) (*Publication, error)
+	// Flush waits until every publication is acked or failed
+	Flush(ctx context.Context) error
+	OnPublishFailed(callback PublishFailedFunc)
+	// Consume starts a durable pull consumer of the stream of its subject
+	Consume(name string, cfg config.ConsumerConfig, handler Handler) (Consumer, error)
+	// Replay feeds the stored messages of a stream to handler until it
+	// caught up with the stream
+	Replay(ctx context.Context, opts ReplayOptions, handler Handler) (ReplayResult, error)
 }
 
 type natsConnection struct {
-	nc     *nats.Conn
-	js     nats.JetStreamContext
-	logger *zap.Logger
+	nc       *nats.Conn
+	js       nats.JetStreamContext
+	encoding string
+	logger   *zap.Logger
+
+	metrics     *publishMetrics
+	pending     sync.WaitGroup
+	callbacksMu sync.RWMutex
+	onFailed    []PublishFailedFunc
 }
 
 func NewNatsConnection(conf config.NatsConfig, logger *zap.Logger) (NatsConnection, error) {
@@ -36,97 +59,102 @@ func NewNatsConnection(conf config.NatsConfig, logger *zap.Logger) (NatsConnecti
 	}
 	logger.Info("Connected to nats successfully.")
 
-	js, err := nc.JetStream()
-	if err != nil {
-		logger.Error("Failed to create jetstream", zap.Error(err))
-		return nil, err
+	ackTimeout := conf.Publish.AckTimeout
+	if ackTimeout == 0 {
+		ackTimeout = defaultAckTimeout
+	}
+	maxPending := conf.Publish.MaxPending
+	if maxPending == 0 {
+		maxPending = defaultMaxPending
 	}
 
-	streamName := conf.StreamConfig.Name
-	streamInfo, err := js.StreamInfo(streamName)
-	if err != nil && err != nats.ErrStreamNotFound {
-		logger.Error("Error getting stream info", zap.Error(err))
+	js, err := nc.JetStream(
+		nats.PublishAsyncTimeout(time.Duration(ackTimeout)*time.Second),
+		nats.PublishAsyncMaxPending(maxPending),
+	)
+	if err != nil {
+		logger.Error("Failed to create jetstream", zap.Error(err))
+		nc.Close()
 		return nil, err
 	}
 
-	if streamInfo != nil {
-		logger.Info("Stream already exists", zap.String("stream", streamName))
-	} else {
-		logger.Info("Stream does not exist, creating new stream", zap.String("stream", streamName))
-
-		// Stream config
-		var retention nats.RetentionPolicy
-		switch conf.StreamConfig.Retention {
-		case "limits":
-			retention = nats.LimitsPolicy
-		case "interest":
-			retention = nats.InterestPolicy
-		case "workqueue":
-			retention = nats.WorkQueuePolicy
-		}
-
-		var discard nats.DiscardPolicy
-		switch conf.StreamConfig.Discard {
-		case "old":
-			discard = nats.DiscardOld
-		case "new":
-			discard = nats.DiscardNew
-		}
-
-		var storage nats.StorageType
-		switch conf.StreamConfig.Storage {
-		case "file":
-			storage = nats.FileStorage
-		case "memory":
-			storage = nats.MemoryStorage
-		}
-
-		streamCfg := nats.StreamConfig{
-			Name:         streamName,
-			Subjects:     []string{"likes.change"},
-			Retention:    retention,
-			Discard:      discard,
-			Storage:      storage,
-			NoAck:        true,
-			MaxConsumers: conf.StreamConfig.MaxConsumers,
-			MaxAge:       conf.StreamConfig.MaxAge * time.Minute,
-		}
-
-		_, err := js.AddStream(&streamCfg)
-		if err != nil {
-			logger.Error("Failed to add stream", zap.Error(err))
+	for _, stream := range conf.Streams {
+		if err := ensureStream(js, stream, logger); err != nil {
+			nc.Close()
 			return nil, err
 		}
+	}
 
-		logger.Info("Stream created successfully", zap.String("stream", streamName))
+	encoding := EncodingJSON
+	if conf.Encoding == "protobuf" {
+		encoding = EncodingProtobuf
+	}
+
+	metrics, err := newPublishMetrics(js)
+	if err != nil {
+		logger.Error("Failed to create publish metrics", zap.Error(err))
+		nc.Close()
+		return nil, err
 	}
 
 	return &natsConnection{
-		nc:     nc,
-		js:     js,
-		logger: logger,
+		nc:       nc,
+		js:       js,
+		encoding: encoding,
+		logger:   logger,
+		metrics:  metrics,
 	}, nil
 }
 
-func (n *natsConnection) Close() {
+func (n *natsConnection) Close(ctx context.Context) error {
+	err := n.Flush(ctx)
+	n.metrics.pending.Unregister()
 	n.nc.Close()
+	return err
 }
 
-func (n *natsConnection) Publish(subject string, message []byte) error {
+func (n *natsConnection) Publish(subject, msgID string, message []byte) (*Publication, error) {
 	n.logger.Info("Publishing message ... ",
 		zap.String("subject", subject),
 		zap.String("message", string(message)),
 	)
 
-	_, err := n.js.PublishAsync(subject, message)
+	msg := nats.NewMsg(subject)
+	msg.Data = message
+	p, err := n.publish(msg, msgID)
 	if err != nil {
 		n.logger.Error("Failed to publish message",
 			zap.Error(err),
 			zap.String("subject", subject),
 			zap.String("message", string(message)),
 		)
-		return err
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (n *natsConnection) PublishEvent(ctx context.Context, subject string, event *Event) (*Publication, error) {
+	msg, err := NewEventMsg(subject, event, n.encoding)
+	if err != nil {
+		n.logger.Error("Invalid event", zap.Error(err), zap.String("subject", subject))
+		return nil, err
 	}
 
-	return nil
+	p, err := n.publish(msg, event.ID)
+	if err != nil {
+		n.logger.Error("Failed to publish event",
+			zap.Error(err),
+			zap.String("subject", subject),
+			zap.String("eventID", event.ID),
+			zap.String("eventType", event.Type),
+		)
+		return nil, err
+	}
+	n.logger.Debug("Event published",
+		zap.String("subject", subject),
+		zap.String("eventID", event.ID),
+		zap.String("eventType", event.Type),
+	)
+	return p, nil
 }
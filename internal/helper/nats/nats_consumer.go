@@ -0,0 +1,215 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mashaghel/internal/config"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchSize  = 10
+	defaultFetchWait  = 5  // In seconds
+	defaultAckWait    = 30 // In seconds
+	defaultMaxDeliver = 5
+
+	// fetchErrorBackoff is the pause after a failed pull request
+	fetchErrorBackoff = time.Second
+)
+
+// Handler processes a single message. The message is acked when the handler
+// returns nil and dropped when it returns a Terminal error, any other error
+// redelivers it until its consumer's max_deliver is reached.
+type Handler func(ctx context.Context, msg *nats.Msg) error
+
+// Consumer is a running durable pull consumer
+type Consumer interface {
+	// Stop stops pulling and waits for the messages in progress until ctx is
+	// done. The durable consumer is kept on the server.
+	Stop(ctx context.Context) error
+}
+
+type terminalError struct {
+	err error
+}
+
+func (e *terminalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *terminalError) Unwrap() error {
+	return e.err
+}
+
+// Terminal marks an error of a message that fails on every delivery, e.g. a
+// malformed payload
+func Terminal(err error) error {
+	return &terminalError{err: err}
+}
+
+// IsTerminal reports whether err is marked Terminal
+func IsTerminal(err error) bool {
+	var terminal *terminalError
+	return errors.As(err, &terminal)
+}
+
+type pullConsumer struct {
+	name      string
+	sub       *nats.Subscription
+	handler   Handler
+	batchSize int
+	fetchWait time.Duration
+	logger    *zap.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Consume creates or updates the durable pull consumer described by cfg and
+// starts handling its messages
+func (n *natsConnection) Consume(name string, cfg config.ConsumerConfig, handler Handler) (Consumer, error) {
+	durable := cfg.Durable
+	if durable == "" {
+		durable = name
+	}
+	ackWait := cfg.AckWait
+	if ackWait == 0 {
+		ackWait = defaultAckWait
+	}
+	maxDeliver := cfg.MaxDeliver
+	if maxDeliver == 0 {
+		maxDeliver = defaultMaxDeliver
+	}
+
+	consumerCfg := &nats.ConsumerConfig{
+		Durable:       durable,
+		FilterSubject: cfg.Subject,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       time.Duration(ackWait) * time.Second,
+		MaxDeliver:    maxDeliver,
+	}
+
+	stream, err := n.js.StreamNameBySubject(cfg.Subject)
+	if err != nil {
+		n.logger.Error("No stream for the consumer subject", zap.Error(err), zap.String("consumer", durable), zap.String("subject", cfg.Subject))
+		return nil, fmt.Errorf("no stream for subject %s of consumer %s: %w", cfg.Subject, durable, err)
+	}
+
+	// The consumer is created here rather than by PullSubscribe, which would
+	// delete it again on unsubscribe
+	_, err = n.js.ConsumerInfo(stream, durable)
+	switch {
+	case errors.Is(err, nats.ErrConsumerNotFound):
+		_, err = n.js.AddConsumer(stream, consumerCfg)
+	case err == nil:
+		_, err = n.js.UpdateConsumer(stream, consumerCfg)
+	}
+	if err != nil {
+		n.logger.Error("Failed to set up consumer", zap.Error(err), zap.String("consumer", durable))
+		return nil, fmt.Errorf("failed to set up consumer %s: %w", durable, err)
+	}
+
+	sub, err := n.js.PullSubscribe(cfg.Subject, durable, nats.Bind(stream, durable))
+	if err != nil {
+		n.logger.Error("Failed to subscribe", zap.Error(err), zap.String("consumer", durable))
+		return nil, err
+	}
+
+	c := &pullConsumer{
+		name:      durable,
+		sub:       sub,
+		handler:   handler,
+		batchSize: cfg.BatchSize,
+		fetchWait: time.Duration(cfg.FetchWait) * time.Second,
+		logger:    n.logger.With(zap.String("consumer", durable), zap.String("stream", stream)),
+		done:      make(chan struct{}),
+	}
+	if c.batchSize == 0 {
+		c.batchSize = defaultBatchSize
+	}
+	if c.fetchWait == 0 {
+		c.fetchWait = defaultFetchWait * time.Second
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	go c.run()
+	c.logger.Info("Consumer started", zap.String("subject", cfg.Subject))
+	return c, nil
+}
+
+func (c *pullConsumer) run() {
+	defer close(c.done)
+
+	for c.ctx.Err() == nil {
+		fetchCtx, cancel := context.WithTimeout(c.ctx, c.fetchWait)
+		msgs, err := c.sub.Fetch(c.batchSize, nats.Context(fetchCtx))
+		cancel()
+		switch {
+		case c.ctx.Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, nats.ErrTimeout):
+			continue
+		case err != nil:
+			c.logger.Error("Failed to fetch messages", zap.Error(err))
+			select {
+			case <-time.After(fetchErrorBackoff):
+			case <-c.ctx.Done():
+			}
+			continue
+		}
+
+		// Handlers finish the batch even while stopping, an unacked message
+		// would only be redelivered after its ack wait
+		for _, msg := range msgs {
+			c.handle(msg)
+		}
+	}
+}
+
+func (c *pullConsumer) handle(msg *nats.Msg) {
+	err := c.handler(context.Background(), msg)
+	if err == nil {
+		if err := msg.Ack(); err != nil {
+			c.logger.Error("Failed to ack message", zap.Error(err), zap.String("subject", msg.Subject))
+		}
+		return
+	}
+
+	fields := []zap.Field{zap.Error(err), zap.String("subject", msg.Subject)}
+	if meta, metaErr := msg.Metadata(); metaErr == nil {
+		fields = append(fields, zap.Uint64("streamSeq", meta.Sequence.Stream), zap.Uint64("delivered", meta.NumDelivered))
+	}
+
+	if IsTerminal(err) {
+		c.logger.Error("Dropping message that cannot be handled", fields...)
+		if err := msg.Term(); err != nil {
+			c.logger.Error("Failed to terminate message", zap.Error(err))
+		}
+		return
+	}
+
+	c.logger.Warn("Failed to handle message, it is redelivered", fields...)
+	if err := msg.Nak(); err != nil {
+		c.logger.Error("Failed to nak message", zap.Error(err))
+	}
+}
+
+func (c *pullConsumer) Stop(ctx context.Context) error {
+	c.once.Do(c.cancel)
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.logger.Info("Consumer stopped")
+	return c.sub.Unsubscribe()
+}
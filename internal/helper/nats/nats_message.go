@@ -1 +1,288 @@
 package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	rpc_service "mashaghel/proto"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Encodings of an event envelope, sent in the Content-Type header of its
+// message
+const (
+	EncodingJSON     = "application/json"
+	EncodingProtobuf = "application/protobuf"
+)
+
+const headerContentType = "Content-Type"
+
+// Event is a typed event with its envelope. The payload is the registered
+// type of Type and Version; it is encoded as protobuf in a protobuf envelope
+// when it is a proto.Message and as JSON otherwise.
+type Event struct {
+	ID         string
+	Type       string
+	Version    int
+	OccurredAt time.Time
+	// W3C trace context of the publisher
+	Trace   map[string]string
+	Payload interface{}
+}
+
+// EventHandler processes a decoded event, see Handler for how its error is
+// treated
+type EventHandler func(ctx context.Context, event *Event) error
+
+// jsonEnvelope is the JSON encoding of an event
+type jsonEnvelope struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Version    int               `json:"version"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	Trace      map[string]string `json:"trace,omitempty"`
+	Payload    json.RawMessage   `json:"payload"`
+}
+
+type eventKey struct {
+	eventType string
+	version   int
+}
+
+var (
+	eventTypesMu sync.RWMutex
+	eventTypes   = map[eventKey]func() interface{}{}
+)
+
+// RegisterEvent makes a version of an event type known. newPayload returns a
+// pointer to a new payload of the version, which is the only payload type
+// published and decoded for it.
+func RegisterEvent(eventType string, version int, newPayload func() interface{}) {
+	eventTypesMu.Lock()
+	defer eventTypesMu.Unlock()
+
+	key := eventKey{eventType: eventType, version: version}
+	if _, exists := eventTypes[key]; exists {
+		panic(fmt.Sprintf("nats: event %s v%d is registered twice", eventType, version))
+	}
+	eventTypes[key] = newPayload
+}
+
+func newEventPayload(eventType string, version int) (interface{}, error) {
+	eventTypesMu.RLock()
+	newPayload, ok := eventTypes[eventKey{eventType: eventType, version: version}]
+	eventTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown event %s v%d", eventType, version)
+	}
+	return newPayload(), nil
+}
+
+// NewEvent returns an event of a registered type occurring now. The trace
+// context of ctx is propagated with it.
+func NewEvent(ctx context.Context, eventType string, version int, payload interface{}) *Event {
+	trace := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, trace)
+	if len(trace) == 0 {
+		trace = nil
+	}
+
+	return &Event{
+		ID:         uuid.NewString(),
+		Type:       eventType,
+		Version:    version,
+		OccurredAt: time.Now().UTC(),
+		Trace:      trace,
+		Payload:    payload,
+	}
+}
+
+// Context returns ctx with the trace context of the event
+func (e *Event) Context(ctx context.Context) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(e.Trace))
+}
+
+// validate checks the envelope and that the payload is of the registered
+// type of the event
+func (e *Event) validate() error {
+	switch {
+	case e.ID == "":
+		return errors.New("event has no id")
+	case e.Type == "":
+		return errors.New("event has no type")
+	case e.OccurredAt.IsZero():
+		return fmt.Errorf("event %s has no occurred_at", e.ID)
+	case e.Payload == nil:
+		return fmt.Errorf("event %s has no payload", e.ID)
+	}
+
+	registered, err := newEventPayload(e.Type, e.Version)
+	if err != nil {
+		return err
+	}
+	if reflect.TypeOf(e.Payload) != reflect.TypeOf(registered) {
+		return fmt.Errorf("payload of event %s v%d is %T, want %T", e.Type, e.Version, e.Payload, registered)
+	}
+	return nil
+}
+
+// EncodeEvent validates an event and encodes it as encoding
+func EncodeEvent(event *Event, encoding string) ([]byte, error) {
+	if err := event.validate(); err != nil {
+		return nil, err
+	}
+
+	switch encoding {
+	case EncodingJSON:
+		var payload []byte
+		var err error
+		if message, ok := event.Payload.(proto.Message); ok {
+			payload, err = protojson.Marshal(message)
+		} else {
+			payload, err = json.Marshal(event.Payload)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode payload of event %s: %w", event.ID, err)
+		}
+		return json.Marshal(jsonEnvelope{
+			ID:         event.ID,
+			Type:       event.Type,
+			Version:    event.Version,
+			OccurredAt: event.OccurredAt,
+			Trace:      event.Trace,
+			Payload:    payload,
+		})
+
+	case EncodingProtobuf:
+		var payload []byte
+		var err error
+		if message, ok := event.Payload.(proto.Message); ok {
+			payload, err = proto.Marshal(message)
+		} else {
+			payload, err = json.Marshal(event.Payload)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode payload of event %s: %w", event.ID, err)
+		}
+		return proto.Marshal(&rpc_service.EventEnvelope{
+			Id:         event.ID,
+			Type:       event.Type,
+			Version:    int32(event.Version),
+			OccurredAt: timestamppb.New(event.OccurredAt),
+			Trace:      event.Trace,
+			Payload:    payload,
+		})
+	}
+	return nil, fmt.Errorf("unknown event encoding %q", encoding)
+}
+
+// DecodeEvent decodes an event encoded as encoding into its registered
+// payload type and validates it
+func DecodeEvent(data []byte, encoding string) (*Event, error) {
+	var event *Event
+	var payload []byte
+
+	switch encoding {
+	case EncodingJSON:
+		var envelope jsonEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, fmt.Errorf("invalid event envelope: %w", err)
+		}
+		event = &Event{
+			ID:         envelope.ID,
+			Type:       envelope.Type,
+			Version:    envelope.Version,
+			OccurredAt: envelope.OccurredAt,
+			Trace:      envelope.Trace,
+		}
+		payload = envelope.Payload
+
+	case EncodingProtobuf:
+		var envelope rpc_service.EventEnvelope
+		if err := proto.Unmarshal(data, &envelope); err != nil {
+			return nil, fmt.Errorf("invalid event envelope: %w", err)
+		}
+		event = &Event{
+			ID:      envelope.GetId(),
+			Type:    envelope.GetType(),
+			Version: int(envelope.GetVersion()),
+			Trace:   envelope.GetTrace(),
+		}
+		if envelope.GetOccurredAt() != nil {
+			event.OccurredAt = envelope.GetOccurredAt().AsTime()
+		}
+		payload = envelope.GetPayload()
+
+	default:
+		return nil, fmt.Errorf("unknown event encoding %q", encoding)
+	}
+
+	value, err := newEventPayload(event.Type, event.Version)
+	if err != nil {
+		return nil, err
+	}
+	message, isProto := value.(proto.Message)
+	switch {
+	case isProto && encoding == EncodingProtobuf:
+		err = proto.Unmarshal(payload, message)
+	case isProto:
+		err = protojson.Unmarshal(payload, message)
+	default:
+		err = json.Unmarshal(payload, value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload of event %s: %w", event.ID, err)
+	}
+	event.Payload = value
+
+	if err := event.validate(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// NewEventMsg returns the message of an event on subject
+func NewEventMsg(subject string, event *Event, encoding string) (*nats.Msg, error) {
+	data, err := EncodeEvent(event, encoding)
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(subject)
+	msg.Header.Set(headerContentType, encoding)
+	msg.Data = data
+	return msg, nil
+}
+
+// DecodeEventMsg decodes the event of a message by its Content-Type, which
+// defaults to JSON
+func DecodeEventMsg(msg *nats.Msg) (*Event, error) {
+	encoding := EncodingJSON
+	if contentType := msg.Header.Get(headerContentType); contentType != "" {
+		encoding = contentType
+	}
+	return DecodeEvent(msg.Data, encoding)
+}
+
+// HandleEvents returns a Handler that decodes the event of every message and
+// passes it to handler within its trace context. A message that is not a
+// valid event fails terminally.
+func HandleEvents(handler EventHandler) Handler {
+	return func(ctx context.Context, msg *nats.Msg) error {
+		event, err := DecodeEventMsg(msg)
+		if err != nil {
+			return Terminal(err)
+		}
+		return handler(event.Context(ctx), event)
+	}
+}
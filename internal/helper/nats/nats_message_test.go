@@ -0,0 +1,79 @@
+package nats
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	Name string `json:"name"`
+}
+
+func init() {
+	RegisterEvent("test.happened", 1, func() interface{} { return &testPayload{} })
+}
+
+func TestEncodeDecodeEvent(t *testing.T) {
+	event := NewEvent(context.Background(), "test.happened", 1, &testPayload{Name: "a"})
+	event.OccurredAt = time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	event.Trace = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+
+	for _, encoding := range []string{EncodingJSON, EncodingProtobuf} {
+		t.Run(encoding, func(t *testing.T) {
+			msg, err := NewEventMsg("test", event, encoding)
+			if err != nil {
+				t.Fatalf("NewEventMsg() error = %v", err)
+			}
+			decoded, err := DecodeEventMsg(msg)
+			if err != nil {
+				t.Fatalf("DecodeEventMsg() error = %v", err)
+			}
+			if !reflect.DeepEqual(decoded, event) {
+				t.Fatalf("DecodeEventMsg() = %+v, want %+v", decoded, event)
+			}
+		})
+	}
+}
+
+func TestEncodeEvent_invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		event *Event
+	}{
+		{name: "unknown type", event: NewEvent(context.Background(), "test.unknown", 1, &testPayload{})},
+		{name: "unknown version", event: NewEvent(context.Background(), "test.happened", 2, &testPayload{})},
+		{name: "wrong payload", event: NewEvent(context.Background(), "test.happened", 1, testPayload{})},
+		{name: "no payload", event: NewEvent(context.Background(), "test.happened", 1, nil)},
+		{name: "no id", event: &Event{Type: "test.happened", Version: 1, OccurredAt: time.Now(), Payload: &testPayload{}}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EncodeEvent(tt.event, EncodingJSON); err == nil {
+				t.Fatal("EncodeEvent() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestDecodeEvent_invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "malformed", data: `{`},
+		{name: "unknown type", data: `{"id":"1","type":"test.unknown","version":1,"occurred_at":"2026-10-18T10:00:00Z","payload":{}}`},
+		{name: "malformed payload", data: `{"id":"1","type":"test.happened","version":1,"occurred_at":"2026-10-18T10:00:00Z","payload":[]}`},
+		{name: "no occurred_at", data: `{"id":"1","type":"test.happened","version":1,"payload":{}}`},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeEvent([]byte(tt.data), EncodingJSON); err == nil {
+				t.Fatal("DecodeEvent() error = nil, want an error")
+			}
+		})
+	}
+}
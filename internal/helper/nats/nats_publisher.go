@@ -0,0 +1,191 @@
+package nats
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+const meterName = "mashaghel/internal/helper/nats"
+
+const (
+	defaultAckTimeout = 10 // In seconds
+	defaultMaxPending = 4000
+)
+
+// PublishFailedFunc is called for a publication the stream rejected or did
+// not ack within the ack timeout
+type PublishFailedFunc func(subject, msgID string, err error)
+
+// Publication is a published message awaiting its ack from the stream
+type Publication struct {
+	Subject string
+	// MsgID is sent as Nats-Msg-Id, the stream drops a message with the ID of
+	// one in its duplicate window and acks it as a duplicate
+	MsgID string
+
+	done chan struct{}
+	ack  *nats.PubAck
+	err  error
+}
+
+// Wait returns the ack of the publication once it arrives or ctx is done
+func (p *Publication) Wait(ctx context.Context) (*nats.PubAck, error) {
+	select {
+	case <-p.done:
+		return p.ack, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// NewPublication returns a publication that already has its ack, or err when
+// it failed, e.g. for a NatsConnection that does not publish to a server
+func NewPublication(subject, msgID string, ack *nats.PubAck, err error) *Publication {
+	p := &Publication{Subject: subject, MsgID: msgID, done: make(chan struct{}), ack: ack, err: err}
+	close(p.done)
+	return p
+}
+
+// publishMetrics are the OTel instruments of the publisher, created on the
+// global meter provider
+type publishMetrics struct {
+	acked      metric.Int64Counter
+	failed     metric.Int64Counter
+	ackLatency metric.Float64Histogram
+	pending    metric.Registration
+}
+
+func newPublishMetrics(js nats.JetStreamContext) (*publishMetrics, error) {
+	meter := otel.Meter(meterName)
+	m := &publishMetrics{}
+
+	var err error
+	if m.acked, err = meter.Int64Counter("nats.publish.acked",
+		metric.WithDescription("Published messages acked by the stream"),
+		metric.WithUnit("{message}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.failed, err = meter.Int64Counter("nats.publish.failed",
+		metric.WithDescription("Published messages rejected or not acked in time"),
+		metric.WithUnit("{message}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.ackLatency, err = meter.Float64Histogram("nats.publish.ack_latency",
+		metric.WithDescription("Time from publishing a message to its ack"),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+
+	pending, err := meter.Int64ObservableGauge("nats.publish.pending",
+		metric.WithDescription("Published messages awaiting their ack"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	m.pending, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(pending, int64(js.PublishAsyncPending()))
+		return nil
+	}, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// OnPublishFailed registers a callback for failed publications. Callbacks
+// run on the goroutine tracking the publication and should not block.
+func (n *natsConnection) OnPublishFailed(callback PublishFailedFunc) {
+	n.callbacksMu.Lock()
+	defer n.callbacksMu.Unlock()
+	n.onFailed = append(n.onFailed, callback)
+}
+
+// publish publishes msg asynchronously with a Nats-Msg-Id of msgID, a new
+// one when empty, and tracks its ack
+func (n *natsConnection) publish(msg *nats.Msg, msgID string) (*Publication, error) {
+	if msgID == "" {
+		msgID = uuid.NewString()
+	}
+	subject := attribute.String("subject", msg.Subject)
+
+	future, err := n.js.PublishMsgAsync(msg, nats.MsgId(msgID))
+	if err != nil {
+		n.metrics.failed.Add(context.Background(), 1, metric.WithAttributes(subject))
+		return nil, err
+	}
+
+	p := &Publication{Subject: msg.Subject, MsgID: msgID, done: make(chan struct{})}
+	n.pending.Add(1)
+	go n.track(p, future, time.Now())
+	return p, nil
+}
+
+// track waits for the ack of a publication. The future always resolves, the
+// JetStream context fails it after the ack timeout.
+func (n *natsConnection) track(p *Publication, future nats.PubAckFuture, publishedAt time.Time) {
+	defer n.pending.Done()
+	defer close(p.done)
+
+	ctx := context.Background()
+	subject := attribute.String("subject", p.Subject)
+
+	select {
+	case p.ack = <-future.Ok():
+		n.metrics.acked.Add(ctx, 1, metric.WithAttributes(subject, attribute.Bool("duplicate", p.ack.Duplicate)))
+		n.metrics.ackLatency.Record(ctx, time.Since(publishedAt).Seconds(), metric.WithAttributes(subject))
+		if p.ack.Duplicate {
+			n.logger.Debug("Duplicate message dropped by the stream", zap.String("subject", p.Subject), zap.String("msgID", p.MsgID))
+		}
+		return
+	case p.err = <-future.Err():
+	}
+
+	n.metrics.failed.Add(ctx, 1, metric.WithAttributes(subject))
+	n.logger.Error("Message was not acked by the stream",
+		zap.Error(p.err),
+		zap.String("subject", p.Subject),
+		zap.String("msgID", p.MsgID),
+	)
+
+	n.callbacksMu.RLock()
+	callbacks := n.onFailed
+	n.callbacksMu.RUnlock()
+	for _, callback := range callbacks {
+		callback(p.Subject, p.MsgID, p.err)
+	}
+}
+
+// Flush waits until every publication is acked or failed, or ctx is done
+func (n *natsConnection) Flush(ctx context.Context) error {
+	select {
+	case <-n.js.PublishAsyncComplete():
+	case <-ctx.Done():
+		n.logger.Warn("Stopped waiting for publish acks", zap.Int("pending", n.js.PublishAsyncPending()))
+		return ctx.Err()
+	}
+
+	// The futures are resolved, the trackers only have their callbacks left
+	tracked := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(tracked)
+	}()
+	select {
+	case <-tracked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
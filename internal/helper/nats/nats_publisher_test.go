@@ -0,0 +1,70 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+type testPubAckFuture struct {
+	ok  chan *nats.PubAck
+	err chan error
+}
+
+func (f *testPubAckFuture) Ok() <-chan *nats.PubAck { return f.ok }
+func (f *testPubAckFuture) Err() <-chan error       { return f.err }
+func (f *testPubAckFuture) Msg() *nats.Msg          { return nil }
+
+func Test_natsConnection_track(t *testing.T) {
+	metrics, err := newPublishMetrics(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errRejected := errors.New("rejected")
+
+	testCases := []struct {
+		name     string
+		ack      *nats.PubAck
+		err      error
+		failures int
+	}{
+		{name: "acked", ack: &nats.PubAck{Sequence: 1}},
+		{name: "duplicate", ack: &nats.PubAck{Sequence: 1, Duplicate: true}},
+		{name: "failed", err: errRejected, failures: 1},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &natsConnection{logger: zap.NewNop(), metrics: metrics}
+			var failures int
+			n.OnPublishFailed(func(subject, msgID string, err error) {
+				if subject != "test" || msgID != "1" || !errors.Is(err, tt.err) {
+					t.Errorf("OnPublishFailed(%s, %s, %v)", subject, msgID, err)
+				}
+				failures++
+			})
+
+			future := &testPubAckFuture{ok: make(chan *nats.PubAck, 1), err: make(chan error, 1)}
+			if tt.err != nil {
+				future.err <- tt.err
+			} else {
+				future.ok <- tt.ack
+			}
+			p := &Publication{Subject: "test", MsgID: "1", done: make(chan struct{})}
+			n.pending.Add(1)
+			n.track(p, future, time.Now())
+
+			ack, err := p.Wait(context.Background())
+			if ack != tt.ack || !errors.Is(err, tt.err) {
+				t.Fatalf("Wait() = %v, %v, want %v, %v", ack, err, tt.ack, tt.err)
+			}
+			if failures != tt.failures {
+				t.Fatalf("OnPublishFailed called %d times, want %d", failures, tt.failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReplayIdleTimeout = 5 * time.Second
+	replayAttempts           = 5
+	replayBackoff            = time.Second
+)
+
+// ReplayOptions selects the messages of a stream to replay. Without a start
+// sequence or time the whole stream is replayed.
+type ReplayOptions struct {
+	Stream    string
+	Subject   string
+	StartSeq  uint64
+	StartTime time.Time
+	// IdleTimeout ends the replay when no message arrived for that long
+	IdleTimeout time.Duration
+}
+
+// ReplayResult counts the replayed messages
+type ReplayResult struct {
+	Handled int
+	// Skipped messages failed with a Terminal error
+	Skipped int
+	// LastSeq is the stream sequence of the last message handled or skipped
+	LastSeq uint64
+}
+
+// Replay feeds the stored messages of a stream to handler in stream order
+// through an ephemeral ordered consumer, until it caught up with the stream.
+// A message the handler fails on is retried and ends the replay when it
+// keeps failing, it can be resumed from LastSeq + 1.
+func (n *natsConnection) Replay(ctx context.Context, opts ReplayOptions, handler Handler) (ReplayResult, error) {
+	var result ReplayResult
+
+	subOpts := []nats.SubOpt{nats.OrderedConsumer(), nats.BindStream(opts.Stream)}
+	switch {
+	case opts.StartSeq > 0:
+		subOpts = append(subOpts, nats.StartSequence(opts.StartSeq))
+	case !opts.StartTime.IsZero():
+		subOpts = append(subOpts, nats.StartTime(opts.StartTime))
+	default:
+		subOpts = append(subOpts, nats.DeliverAll())
+	}
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultReplayIdleTimeout
+	}
+
+	sub, err := n.js.SubscribeSync(opts.Subject, subOpts...)
+	if err != nil {
+		n.logger.Error("Failed to create replay consumer", zap.Error(err), zap.String("stream", opts.Stream))
+		return result, err
+	}
+	defer sub.Unsubscribe()
+
+	logger := n.logger.With(zap.String("stream", opts.Stream), zap.String("subject", opts.Subject))
+	logger.Info("Replaying stream")
+
+	for {
+		nextCtx, cancel := context.WithTimeout(ctx, idleTimeout)
+		msg, err := sub.NextMsgWithContext(nextCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return result, ctx.Err()
+		case errors.Is(err, context.DeadlineExceeded):
+			logger.Info("No more messages to replay", zap.Int("handled", result.Handled), zap.Int("skipped", result.Skipped))
+			return result, nil
+		case err != nil:
+			return result, err
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			return result, err
+		}
+		if err := n.replayMsg(ctx, msg, handler); err != nil {
+			if !IsTerminal(err) {
+				return result, fmt.Errorf("message %d: %w", meta.Sequence.Stream, err)
+			}
+			logger.Warn("Skipping message that cannot be handled", zap.Error(err), zap.Uint64("streamSeq", meta.Sequence.Stream))
+			result.Skipped++
+		} else {
+			result.Handled++
+		}
+		result.LastSeq = meta.Sequence.Stream
+
+		if (result.Handled+result.Skipped)%1000 == 0 {
+			logger.Info("Replay progress", zap.Int("handled", result.Handled), zap.Uint64("streamSeq", result.LastSeq), zap.Uint64("pending", meta.NumPending))
+		}
+		if meta.NumPending == 0 {
+			logger.Info("Replay caught up with the stream", zap.Int("handled", result.Handled), zap.Int("skipped", result.Skipped))
+			return result, nil
+		}
+	}
+}
+
+// replayMsg handles a message, retrying the errors that are not Terminal
+func (n *natsConnection) replayMsg(ctx context.Context, msg *nats.Msg, handler Handler) error {
+	var err error
+	for attempt := 1; attempt <= replayAttempts; attempt++ {
+		if err = handler(ctx, msg); err == nil || IsTerminal(err) {
+			return err
+		}
+		n.logger.Warn("Failed to replay message, retrying", zap.Error(err), zap.Int("attempt", attempt))
+
+		select {
+		case <-time.After(replayBackoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"mashaghel/internal/config"
+	"reflect"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// unlimited is how JetStream reports a limit that is not set
+const unlimited = -1
+
+// streamConfig returns the JetStream config of a stream in the nats config.
+// Unset limits are unlimited, unset replicas a single one.
+func streamConfig(conf config.StreamConfig) nats.StreamConfig {
+	var retention nats.RetentionPolicy
+	switch conf.Retention {
+	case "limits":
+		retention = nats.LimitsPolicy
+	case "interest":
+		retention = nats.InterestPolicy
+	case "workqueue":
+		retention = nats.WorkQueuePolicy
+	}
+
+	var discard nats.DiscardPolicy
+	switch conf.Discard {
+	case "old":
+		discard = nats.DiscardOld
+	case "new":
+		discard = nats.DiscardNew
+	}
+
+	var storage nats.StorageType
+	switch conf.Storage {
+	case "file":
+		storage = nats.FileStorage
+	case "memory":
+		storage = nats.MemoryStorage
+	}
+
+	orUnlimited := func(limit int64) int64 {
+		if limit == 0 {
+			return unlimited
+		}
+		return limit
+	}
+	replicas := conf.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+
+	return nats.StreamConfig{
+		Name:              conf.Name,
+		Subjects:          conf.Subjects,
+		Retention:         retention,
+		Discard:           discard,
+		Storage:           storage,
+		Replicas:          replicas,
+		NoAck:             conf.NoAck,
+		MaxConsumers:      int(orUnlimited(int64(conf.MaxConsumers))),
+		MaxMsgs:           orUnlimited(conf.MaxMsgs),
+		MaxBytes:          orUnlimited(conf.MaxBytes),
+		MaxMsgSize:        int32(orUnlimited(int64(conf.MaxMsgSize))),
+		MaxMsgsPerSubject: orUnlimited(conf.MaxMsgsPerSubject),
+		MaxAge:            conf.MaxAge * time.Minute,
+		Duplicates:        conf.Duplicates * time.Minute,
+	}
+}
+
+// streamConfigDiff returns the fields the nats config manages that differ
+// between the live config of a stream and the desired one, as
+// "field: live -> desired". An unset duplicate window keeps the live one.
+func streamConfigDiff(live, desired nats.StreamConfig) []string {
+	fields := []struct {
+		name          string
+		live, desired interface{}
+	}{
+		{"subjects", live.Subjects, desired.Subjects},
+		{"retention", live.Retention, desired.Retention},
+		{"discard", live.Discard, desired.Discard},
+		{"storage", live.Storage, desired.Storage},
+		{"replicas", live.Replicas, desired.Replicas},
+		{"no_ack", live.NoAck, desired.NoAck},
+		{"max_consumers", live.MaxConsumers, desired.MaxConsumers},
+		{"max_msgs", live.MaxMsgs, desired.MaxMsgs},
+		{"max_bytes", live.MaxBytes, desired.MaxBytes},
+		{"max_msg_size", live.MaxMsgSize, desired.MaxMsgSize},
+		{"max_msgs_per_subject", live.MaxMsgsPerSubject, desired.MaxMsgsPerSubject},
+		{"max_age", live.MaxAge, desired.MaxAge},
+	}
+	if desired.Duplicates != 0 {
+		fields = append(fields, struct {
+			name          string
+			live, desired interface{}
+		}{"duplicates", live.Duplicates, desired.Duplicates})
+	}
+
+	var diff []string
+	for _, field := range fields {
+		if !reflect.DeepEqual(field.live, field.desired) {
+			diff = append(diff, fmt.Sprintf("%s: %v -> %v", field.name, field.live, field.desired))
+		}
+	}
+	return diff
+}
+
+// ensureStream creates a stream of the nats config or updates the live one
+// when its config drifted. Fields the nats config does not manage are kept.
+func ensureStream(js nats.JetStreamContext, conf config.StreamConfig, logger *zap.Logger) error {
+	logger = logger.With(zap.String("stream", conf.Name))
+	desired := streamConfig(conf)
+
+	info, err := js.StreamInfo(conf.Name)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		logger.Info("Stream does not exist, creating new stream")
+		if _, err := js.AddStream(&desired); err != nil {
+			logger.Error("Failed to add stream", zap.Error(err))
+			return err
+		}
+		logger.Info("Stream created successfully")
+		return nil
+	}
+	if err != nil {
+		logger.Error("Error getting stream info", zap.Error(err))
+		return err
+	}
+
+	diff := streamConfigDiff(info.Config, desired)
+	if len(diff) == 0 {
+		logger.Info("Stream already exists")
+		return nil
+	}
+
+	updated := info.Config
+	updated.Subjects = desired.Subjects
+	updated.Retention = desired.Retention
+	updated.Discard = desired.Discard
+	updated.Storage = desired.Storage
+	updated.Replicas = desired.Replicas
+	updated.NoAck = desired.NoAck
+	updated.MaxConsumers = desired.MaxConsumers
+	updated.MaxMsgs = desired.MaxMsgs
+	updated.MaxBytes = desired.MaxBytes
+	updated.MaxMsgSize = desired.MaxMsgSize
+	updated.MaxMsgsPerSubject = desired.MaxMsgsPerSubject
+	updated.MaxAge = desired.MaxAge
+	if desired.Duplicates != 0 {
+		updated.Duplicates = desired.Duplicates
+	}
+
+	logger.Info("Stream config changed, updating stream", zap.Strings("diff", diff))
+	if _, err := js.UpdateStream(&updated); err != nil {
+		// Some fields, like the storage, can not change on a live stream
+		logger.Error("Failed to update stream", zap.Error(err), zap.Strings("diff", diff))
+		return err
+	}
+	logger.Info("Stream updated successfully")
+	return nil
+}
@@ -0,0 +1,75 @@
+package nats
+
+import (
+	"mashaghel/internal/config"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func Test_streamConfigDiff(t *testing.T) {
+	conf := config.StreamConfig{
+		Name:         "UserInteraction",
+		Subjects:     []string{"likes.change"},
+		Retention:    "limits",
+		Discard:      "old",
+		Storage:      "file",
+		MaxConsumers: 2,
+		MaxAge:       2,
+	}
+	live := streamConfig(conf)
+	// Set by the server when the stream was created without a window
+	live.Duplicates = 2 * time.Minute
+
+	testCases := []struct {
+		name   string
+		change func(c *config.StreamConfig)
+		want   []string
+	}{
+		{name: "unchanged", change: func(c *config.StreamConfig) {}},
+		{name: "unset duplicates keep the live window", change: func(c *config.StreamConfig) { c.Duplicates = 0 }},
+		{
+			name:   "subjects",
+			change: func(c *config.StreamConfig) { c.Subjects = []string{"likes.change", "views.change"} },
+			want:   []string{"subjects: [likes.change] -> [likes.change views.change]"},
+		},
+		{
+			name: "limits",
+			change: func(c *config.StreamConfig) {
+				c.MaxMsgs = 100
+				c.MaxAge = 60
+				c.Replicas = 3
+			},
+			want: []string{"replicas: 1 -> 3", "max_msgs: -1 -> 100", "max_age: 2m0s -> 1h0m0s"},
+		},
+		{
+			name:   "no ack",
+			change: func(c *config.StreamConfig) { c.NoAck = true },
+			want:   []string{"no_ack: false -> true"},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			desired := conf
+			tt.change(&desired)
+			got := streamConfigDiff(live, streamConfig(desired))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("streamConfigDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_streamConfig_unlimited(t *testing.T) {
+	got := streamConfig(config.StreamConfig{Retention: "limits", Discard: "old", Storage: "memory"})
+	if got.MaxMsgs != unlimited || got.MaxBytes != unlimited || got.MaxMsgSize != unlimited ||
+		got.MaxMsgsPerSubject != unlimited || got.MaxConsumers != unlimited || got.Replicas != 1 {
+		t.Fatalf("streamConfig() = %+v, want unlimited limits and a single replica", got)
+	}
+	if got.Storage != nats.MemoryStorage {
+		t.Fatalf("streamConfig().Storage = %v, want %v", got.Storage, nats.MemoryStorage)
+	}
+}
@@ -0,0 +1,95 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	natsHelper "mashaghel/internal/helper/nats"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Shards is the number of outbox shards. The events of an aggregate key
+// always go to the same shard, so they are relayed in order. Changing it
+// reorders the pending events, the outbox has to be drained first.
+const Shards = 16
+
+const (
+	// BucketSize is the time span of an outbox partition. A shard is split
+	// into a partition per bucket, which the relay reads once and moves past.
+	BucketSize = time.Hour
+	// Retention is how long an event is kept, the default_time_to_live of
+	// outbox_events. An event that is not relayed by then is lost.
+	Retention = 7 * 24 * time.Hour
+)
+
+const queryInsertOutbox = `INSERT INTO outbox_events (shard, bucket, id, aggregate_key, subject, event) VALUES (?, ?, ?, ?, ?, ?);`
+
+// ErrUnloggedBatch is returned when an event is added to a batch that does
+// not apply atomically
+var ErrUnloggedBatch = errors.New("outbox: events must be added to a logged batch")
+
+// Shard returns the outbox shard of an aggregate key
+func Shard(aggregateKey string) int {
+	h := fnv.New32a()
+	h.Write([]byte(aggregateKey))
+	return int(h.Sum32() % Shards)
+}
+
+// Bucket returns the bucket of the outbox partition an event added at t
+// goes to
+func Bucket(t time.Time) time.Time {
+	return t.UTC().Truncate(BucketSize)
+}
+
+// Add records an event in the logged batch of the domain write it belongs
+// to, so it is stored if and only if the write is. The events of an
+// aggregate key are published in the order they were added. The event is
+// stored as a JSON envelope and keeps its ID, which deduplicates a relay
+// that publishes it twice.
+func Add(batch *gocql.Batch, subject, aggregateKey string, event *natsHelper.Event) error {
+	if batch.Type != gocql.LoggedBatch {
+		return ErrUnloggedBatch
+	}
+	data, err := natsHelper.EncodeEvent(event, natsHelper.EncodingJSON)
+	if err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+
+	id := gocql.TimeUUID()
+	batch.Query(queryInsertOutbox, Shard(aggregateKey), Bucket(id.Time()), id, aggregateKey, subject, data)
+	return nil
+}
+
+// Collection is the Arango collection of the outbox. Arango writes insert the
+// document of their event into it in the AQL query of the write.
+const Collection = "outbox_collection"
+
+// Document is an event in the Arango outbox. The relay removes it once it is
+// published.
+type Document struct {
+	Key          string `json:"_key"` // ID of the event
+	AggregateKey string `json:"aggregate_key"`
+	Subject      string `json:"subject"`
+	Event        string `json:"event"`      // JSON envelope
+	CreatedAt    int64  `json:"created_at"` // In microseconds since the epoch
+}
+
+// NewDocument returns the outbox document of an event, which the AQL query
+// of the domain write inserts into Collection, so it is stored if and only if
+// the write is. The events of an aggregate key are published in the order
+// they were created.
+func NewDocument(subject, aggregateKey string, event *natsHelper.Event) (*Document, error) {
+	data, err := natsHelper.EncodeEvent(event, natsHelper.EncodingJSON)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	return &Document{
+		Key:          event.ID,
+		AggregateKey: aggregateKey,
+		Subject:      subject,
+		Event:        string(data),
+		CreatedAt:    time.Now().UnixMicro(),
+	}, nil
+}
@@ -0,0 +1,89 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	natsHelper "mashaghel/internal/helper/nats"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type testPayload struct {
+	Name string `json:"name"`
+}
+
+func init() {
+	natsHelper.RegisterEvent("outbox.test", 1, func() interface{} { return &testPayload{} })
+}
+
+func TestShard(t *testing.T) {
+	for _, key := range []string{"", "a", "7f1c2a44-8a7b-11ef-b864-0242ac120002"} {
+		shard := Shard(key)
+		if shard < 0 || shard >= Shards {
+			t.Fatalf("Shard(%q) = %d, want in [0, %d)", key, shard, Shards)
+		}
+		if Shard(key) != shard {
+			t.Fatalf("Shard(%q) is not stable", key)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	at := time.Date(2026, 10, 18, 13, 59, 59, 0, time.FixedZone("IRST", 12600))
+	if got, want := Bucket(at), time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("Bucket(%v) = %v, want %v", at, got, want)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	event := natsHelper.NewEvent(context.Background(), "outbox.test", 1, &testPayload{Name: "a"})
+
+	unlogged := &gocql.Batch{Type: gocql.UnloggedBatch}
+	if err := Add(unlogged, "test", "a", event); !errors.Is(err, ErrUnloggedBatch) {
+		t.Fatalf("Add() to an unlogged batch error = %v, want %v", err, ErrUnloggedBatch)
+	}
+
+	logged := &gocql.Batch{Type: gocql.LoggedBatch}
+	if err := Add(logged, "test", "a", natsHelper.NewEvent(context.Background(), "outbox.unknown", 1, &testPayload{})); err == nil {
+		t.Fatal("Add() of an unknown event error = nil, want an error")
+	}
+	if err := Add(logged, "test", "a", event); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if logged.Size() != 1 {
+		t.Fatalf("batch has %d statements, want 1", logged.Size())
+	}
+
+	args := logged.Entries[0].Args
+	decoded, err := natsHelper.DecodeEvent(args[5].([]byte), natsHelper.EncodingJSON)
+	if err != nil {
+		t.Fatalf("DecodeEvent() error = %v", err)
+	}
+	if args[0] != Shard("a") || args[3] != "a" || args[4] != "test" || decoded.ID != event.ID {
+		t.Fatalf("Add() added %v, want the event %s of aggregate a", args, event.ID)
+	}
+	if id := args[2].(gocql.UUID); args[1] != Bucket(id.Time()) {
+		t.Fatalf("Add() added the event %s to bucket %v, want %v", id, args[1], Bucket(id.Time()))
+	}
+}
+
+func TestNewDocument(t *testing.T) {
+	if _, err := NewDocument("test", "a", natsHelper.NewEvent(context.Background(), "outbox.unknown", 1, &testPayload{})); err == nil {
+		t.Fatal("NewDocument() of an unknown event error = nil, want an error")
+	}
+
+	event := natsHelper.NewEvent(context.Background(), "outbox.test", 1, &testPayload{Name: "a"})
+	document, err := NewDocument("test", "a", event)
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+	decoded, err := natsHelper.DecodeEvent([]byte(document.Event), natsHelper.EncodingJSON)
+	if err != nil {
+		t.Fatalf("DecodeEvent() error = %v", err)
+	}
+	if document.Key != event.ID || document.AggregateKey != "a" || document.Subject != "test" || decoded.ID != event.ID {
+		t.Fatalf("NewDocument() = %+v, want the event %s of aggregate a", document, event.ID)
+	}
+}
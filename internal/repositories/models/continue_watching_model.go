@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// ContinueWatchingItem is a partially watched video of a profile
+type ContinueWatchingItem struct {
+	PlayID    string    `json:"play_id"`
+	WatchedAt time.Time `json:"watched_at"`
+	Duration  int       `json:"duration"` // In seconds
+	Length    int       `json:"length"`   // In seconds
+	Progress  float64   `json:"progress"` // Share of the video watched
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+	// JobRunInterrupted runs stopped early on shutdown
+	JobRunInterrupted = "interrupted"
+)
+
+// JobRun is a single run of a background job
+type JobRun struct {
+	JobName       string     `json:"job_name"`
+	RunID         string     `json:"run_id"`
+	Host          string     `json:"host"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	Duration      string     `json:"duration,omitempty"`
+	Status        string     `json:"status"`
+	RowsProcessed int64      `json:"rows_processed"`
+	Error         string     `json:"error,omitempty"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// TrendingKeyPrefix prefixes the Redis sorted sets of the trending videos
+const TrendingKeyPrefix = "mashaghel:trending"
+
+// Dimensions the trending videos are ranked by
+const (
+	TrendingByCategory = "category"
+	TrendingByType     = "type"
+)
+
+// TrendingKey is the sorted set of the trending videos of a category or type
+func TrendingKey(dimension, value string) string {
+	return TrendingKeyPrefix + ":" + dimension + ":" + value
+}
+
+// TrendingVideo is a video of a trending row with its decayed score
+type TrendingVideo struct {
+	Video
+	Score float64 `json:"score"`
+}
@@ -1,11 +1,16 @@
 package models
 
+// VideosCollection is the Arango collection of the videos
+const VideosCollection = "videos_collection"
+
 type Video struct {
-	Key         string   `json:"_key" validate:"required"`
-	Publishable bool     `json:"publishable" validate:"required"`
-	Categories  []string `json:"categories" validate:"required,dive,required"`
-	Description string   `json:"description,omitempty"`
-	Name        string   `json:"name" validate:"required"`
-	Type        string   `json:"type,omitempty" validate:"oneof=movie series tvshow"`
-	Views       int      `json:"views,omitempty" validate:"gte=0"`
+	Key           string   `json:"_key" validate:"required"`
+	Publishable   bool     `json:"publishable" validate:"required"`
+	Categories    []string `json:"categories" validate:"required,dive,required"`
+	Description   string   `json:"description,omitempty"`
+	Name          string   `json:"name" validate:"required"`
+	Type          string   `json:"type,omitempty" validate:"oneof=movie series tvshow"`
+	Views         int      `json:"views,omitempty" validate:"gte=0"`
+	Length        int      `json:"length,omitempty" validate:"gte=0"` // In seconds
+	ViewsPosition string   `json:"views_position,omitempty"`          // Position in video_view_log the views are counted up to
 }
@@ -11,6 +11,8 @@ import (
 
 type Repository interface {
 	SystemRepository() SystemRepository
+	WatchRepository() WatchRepository
+	VideoRepository() VideoRepository
 }
 
 // var (
@@ -19,15 +21,27 @@ type Repository interface {
 
 type repository struct {
 	systemRepository SystemRepository
+	watchRepository  WatchRepository
+	videoRepository  VideoRepository
 }
 
 func NewRepository(arango arango.ArangoDB, redis producers.RedisClient, scyllaDB scylla.ScyllaDB, logger *zap.Logger, ctx context.Context) Repository {
 	systemRepository := NewSystemRepository(arango, redis, scyllaDB)
 	return &repository{
 		systemRepository: systemRepository,
+		watchRepository:  NewWatchRepository(scyllaDB),
+		videoRepository:  NewVideoRepository(arango, redis),
 	}
 }
 
 func (r *repository) SystemRepository() SystemRepository {
 	return r.systemRepository
 }
+
+func (r *repository) WatchRepository() WatchRepository {
+	return r.watchRepository
+}
+
+func (r *repository) VideoRepository() VideoRepository {
+	return r.videoRepository
+}
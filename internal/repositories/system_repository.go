@@ -5,11 +5,19 @@ import (
 	"mashaghel/internal/database/arango"
 	"mashaghel/internal/database/scylla"
 	"mashaghel/internal/producers"
+	"mashaghel/internal/repositories/models"
+	"time"
+
+	"github.com/gocql/gocql"
 )
 
+const querySelectJobRuns = `SELECT run_id, host, started_at, finished_at, status, rows_processed, error FROM job_runs WHERE job_name = ? LIMIT ?;`
+
 type SystemRepository interface {
 	ArangoPing(ctx context.Context) error
 	RedisPing(ctx context.Context) error
+	// JobRuns returns the latest runs of a background job, newest first
+	JobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error)
 }
 
 type systemRepository struct {
@@ -42,3 +50,28 @@ func (r *systemRepository) ScyllaDBPing(ctx context.Context) error {
 	}
 	return nil
 }
+
+func (r *systemRepository) JobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error) {
+	iter := r.scyllaDB.Session().Query(querySelectJobRuns, jobName, limit).WithContext(ctx).Iter()
+
+	runs := make([]models.JobRun, 0, limit)
+	var runID gocql.UUID
+	var finishedAt time.Time
+	run := models.JobRun{JobName: jobName}
+	for iter.Scan(&runID, &run.Host, &run.StartedAt, &finishedAt, &run.Status, &run.RowsProcessed, &run.Error) {
+		run.RunID = runID.String()
+		run.FinishedAt = nil
+		run.Duration = ""
+		if !finishedAt.IsZero() {
+			finished := finishedAt
+			run.FinishedAt = &finished
+			run.Duration = finishedAt.Sub(run.StartedAt).String()
+		}
+		runs = append(runs, run)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
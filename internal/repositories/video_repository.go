@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"context"
+	"mashaghel/internal/database/arango"
+	"mashaghel/internal/producers"
+	"mashaghel/internal/repositories/models"
+
+	"github.com/arangodb/go-driver/v2/arangodb"
+)
+
+const queryVideosByKeys = `FOR key IN @keys LET v = DOCUMENT(@@collection, key) FILTER v != null RETURN v`
+
+type VideoRepository interface {
+	// Trending returns the top videos of a trending row, highest score first
+	Trending(ctx context.Context, dimension, value string, limit int) ([]models.TrendingVideo, error)
+}
+
+type videoRepository struct {
+	arango arango.ArangoDB
+	redis  producers.RedisClient
+}
+
+func NewVideoRepository(arango arango.ArangoDB, redis producers.RedisClient) VideoRepository {
+	return &videoRepository{arango: arango, redis: redis}
+}
+
+func (r *videoRepository) Trending(ctx context.Context, dimension, value string, limit int) ([]models.TrendingVideo, error) {
+	members, err := r.redis.RedisStorage().Conn().
+		ZRevRangeWithScores(ctx, models.TrendingKey(dimension, value), 0, int64(limit-1)).
+		Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return []models.TrendingVideo{}, nil
+	}
+
+	keys := make([]string, 0, len(members))
+	scores := make(map[string]float64, len(members))
+	for _, member := range members {
+		key, _ := member.Member.(string)
+		keys = append(keys, key)
+		scores[key] = member.Score
+	}
+
+	cursor, err := r.arango.Database(ctx).Query(ctx, queryVideosByKeys, &arangodb.QueryOptions{
+		BindVars: map[string]interface{}{
+			"@collection": models.VideosCollection,
+			"keys":        keys,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	videos := make([]models.TrendingVideo, 0, len(keys))
+	for cursor.HasMore() {
+		var video models.TrendingVideo
+		if _, err := cursor.ReadDocument(ctx, &video.Video); err != nil {
+			return nil, err
+		}
+		video.Score = scores[video.Key]
+		videos = append(videos, video)
+	}
+
+	return videos, nil
+}
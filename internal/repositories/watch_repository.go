@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/repositories/models"
+
+	"github.com/gocql/gocql"
+)
+
+const querySelectContinueWatching = `SELECT play_id, watched_at, duration, length FROM continue_watching WHERE profile_id = ? LIMIT ?;`
+
+type WatchRepository interface {
+	// ContinueWatching returns the partially watched videos of a profile,
+	// latest first
+	ContinueWatching(ctx context.Context, profileID gocql.UUID, limit int) ([]models.ContinueWatchingItem, error)
+}
+
+type watchRepository struct {
+	scyllaDB scylla.ScyllaDB
+}
+
+func NewWatchRepository(scyllaDB scylla.ScyllaDB) WatchRepository {
+	return &watchRepository{scyllaDB: scyllaDB}
+}
+
+func (r *watchRepository) ContinueWatching(ctx context.Context, profileID gocql.UUID, limit int) ([]models.ContinueWatchingItem, error) {
+	iter := r.scyllaDB.Session().Query(querySelectContinueWatching, profileID, limit).WithContext(ctx).Iter()
+
+	items := make([]models.ContinueWatchingItem, 0, limit)
+	var playID, watchedAt gocql.UUID
+	var item models.ContinueWatchingItem
+	for iter.Scan(&playID, &watchedAt, &item.Duration, &item.Length) {
+		item.PlayID = playID.String()
+		item.WatchedAt = watchedAt.Time()
+		item.Progress = 0
+		if item.Length > 0 {
+			item.Progress = float64(item.Duration) / float64(item.Length)
+		}
+		items = append(items, item)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
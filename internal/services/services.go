@@ -1,23 +1,29 @@
 package services
 
-import "mashaghel/internal/repositories"
+import (
+	"mashaghel/internal/repositories"
+	"mashaghel/internal/tasks"
+)
 
 type Service interface {
 	RpcServiceService() RpcServiceService
 	SystemService() SystemService
+	VideoService() VideoService
 }
 
 type service struct {
 	rpcServiceService RpcServiceService
 	systemService     SystemService
+	videoService      VideoService
 }
 
-func NewService(repo repositories.Repository) Service {
+func NewService(repo repositories.Repository, task tasks.Task) Service {
 	rpcServiceService := NewRpcServiceService()
-	systemService := NewSystemService(repo.SystemRepository())
+	systemService := NewSystemService(repo.SystemRepository(), task)
 	return &service{
 		rpcServiceService: rpcServiceService,
 		systemService:     systemService,
+		videoService:      NewVideoService(repo.VideoRepository()),
 	}
 }
 
@@ -28,3 +34,7 @@ func (s *service) RpcServiceService() RpcServiceService {
 func (s *service) SystemService() SystemService {
 	return s.systemService
 }
+
+func (s *service) VideoService() VideoService {
+	return s.videoService
+}
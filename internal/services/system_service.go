@@ -2,9 +2,19 @@ package services
 
 import (
 	"context"
+	"errors"
 	"mashaghel/internal/repositories"
+	"mashaghel/internal/repositories/models"
+	"mashaghel/internal/tasks"
 )
 
+const (
+	DefaultJobRunsLimit = 20
+	MaxJobRunsLimit     = 100
+)
+
+var ErrInvalidJobRunsLimit = errors.New("invalid job runs limit")
+
 type RepoStatus struct {
 	Healthy bool
 	Error   error
@@ -12,14 +22,20 @@ type RepoStatus struct {
 
 type SystemService interface {
 	ReadyCheck(ctx context.Context) (map[string]RepoStatus, []error)
+	// JobRuns returns the latest runs of a background job, newest first. A
+	// zero limit returns DefaultJobRunsLimit runs.
+	JobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error)
+	// TriggerJob enqueues an immediate run of a job on this instance
+	TriggerJob(ctx context.Context, jobName string) error
 }
 
 type systemService struct {
 	systemRepository repositories.SystemRepository
+	task             tasks.Task
 }
 
-func NewSystemService(systemRepository repositories.SystemRepository) SystemService {
-	return &systemService{systemRepository: systemRepository}
+func NewSystemService(systemRepository repositories.SystemRepository, task tasks.Task) SystemService {
+	return &systemService{systemRepository: systemRepository, task: task}
 }
 
 func (s *systemService) ReadyCheck(ctx context.Context) (map[string]RepoStatus, []error) {
@@ -50,3 +66,25 @@ func (s *systemService) ReadyCheck(ctx context.Context) (map[string]RepoStatus,
 	return statuses, nil
 
 }
+
+func (s *systemService) JobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error) {
+	if limit == 0 {
+		limit = DefaultJobRunsLimit
+	}
+	if limit < 0 || limit > MaxJobRunsLimit {
+		return nil, &ServiceErr{Err: ErrInvalidJobRunsLimit, Msg: "limit must be between 1 and 100"}
+	}
+
+	runs, err := s.systemRepository.JobRuns(ctx, jobName, limit)
+	if err != nil {
+		return nil, &ServiceErr{Err: err, Msg: "failed to load job runs"}
+	}
+	return runs, nil
+}
+
+func (s *systemService) TriggerJob(ctx context.Context, jobName string) error {
+	if err := s.task.Trigger(jobName); err != nil {
+		return &ServiceErr{Err: err, Msg: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/repositories"
+	"mashaghel/internal/repositories/models"
+)
+
+const (
+	DefaultTrendingLimit = 20
+	MaxTrendingLimit     = 50
+)
+
+var ErrInvalidTrendingQuery = errors.New("invalid trending query")
+
+type VideoService interface {
+	// Trending returns the trending videos of either a category or a type. A
+	// zero limit returns DefaultTrendingLimit videos.
+	Trending(ctx context.Context, category, videoType string, limit int) ([]models.TrendingVideo, error)
+}
+
+type videoService struct {
+	videoRepository repositories.VideoRepository
+}
+
+func NewVideoService(videoRepository repositories.VideoRepository) VideoService {
+	return &videoService{videoRepository: videoRepository}
+}
+
+func (s *videoService) Trending(ctx context.Context, category, videoType string, limit int) ([]models.TrendingVideo, error) {
+	if limit == 0 {
+		limit = DefaultTrendingLimit
+	}
+	if limit < 0 || limit > MaxTrendingLimit {
+		return nil, &ServiceErr{Err: ErrInvalidTrendingQuery, Msg: "limit must be between 1 and 50"}
+	}
+	if (category == "") == (videoType == "") {
+		return nil, &ServiceErr{Err: ErrInvalidTrendingQuery, Msg: "either category or type is required"}
+	}
+
+	dimension, value := models.TrendingByCategory, category
+	if videoType != "" {
+		switch videoType {
+		case "movie", "series", "tvshow":
+		default:
+			return nil, &ServiceErr{Err: ErrInvalidTrendingQuery, Msg: "type must be one of movie, series, tvshow"}
+		}
+		dimension, value = models.TrendingByType, videoType
+	}
+
+	videos, err := s.videoRepository.Trending(ctx, dimension, value, limit)
+	if err != nil {
+		return nil, &ServiceErr{Err: err, Msg: "failed to load trending videos"}
+	}
+	return videos, nil
+}
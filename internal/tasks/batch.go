@@ -0,0 +1,169 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/scylla"
+	natsHelper "mashaghel/internal/helper/nats"
+	"mashaghel/internal/outbox"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchMaxStatements = 50
+	defaultBatchConsistency   = "any"
+)
+
+// batchStatement is a statement written by a batchWriter. Key names the item
+// the statement belongs to, so failures can be reported per item. Event is
+// added to the outbox in the batch of the statement.
+type batchStatement struct {
+	Key   string
+	Query string
+	Args  []interface{}
+	Event *batchEvent
+}
+
+// batchEvent is an outbox event of a batchStatement, see outbox.Add
+type batchEvent struct {
+	Subject      string
+	AggregateKey string
+	Event        *natsHelper.Event
+}
+
+// batchWriter executes statements in bounded chunks and retries every chunk
+// on its own, so that a failing chunk does not drop the rest of the set
+type batchWriter struct {
+	scylla        scylla.ScyllaDB
+	logger        *zap.Logger
+	metrics       *jobMetrics
+	jobName       string
+	maxStatements int
+	consistency   gocql.Consistency
+	batchType     gocql.BatchType
+	retries       int
+	retryBackoff  time.Duration
+}
+
+func newBatchWriter(scyllaDB scylla.ScyllaDB, cfg config.BatchConfig, jobName string, metrics *jobMetrics, logger *zap.Logger) (*batchWriter, error) {
+	consistencyName := cfg.Consistency
+	if consistencyName == "" {
+		consistencyName = defaultBatchConsistency
+	}
+	consistency, err := gocql.ParseConsistencyWrapper(strings.ToUpper(consistencyName))
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch consistency: %w", err)
+	}
+
+	maxStatements := cfg.MaxStatements
+	if maxStatements == 0 {
+		maxStatements = defaultBatchMaxStatements
+	}
+
+	// Every chunk targets a single partition of a single table, a logged
+	// batch only buys atomicity across partitions
+	batchType := gocql.UnloggedBatch
+	if cfg.Logged {
+		batchType = gocql.LoggedBatch
+	}
+
+	return &batchWriter{
+		scylla:        scyllaDB,
+		logger:        logger,
+		metrics:       metrics,
+		jobName:       jobName,
+		maxStatements: maxStatements,
+		consistency:   consistency,
+		batchType:     batchType,
+		retries:       cfg.Retries,
+		retryBackoff:  time.Duration(cfg.RetryBackoff) * time.Millisecond,
+	}, nil
+}
+
+// Write executes the statements, which must all target the same partition,
+// and returns the keys of the statements whose chunk failed
+func (w *batchWriter) Write(ctx context.Context, statements []batchStatement) map[string]error {
+	failed := make(map[string]error)
+
+	for start := 0; start < len(statements); start += w.maxStatements {
+		end := min(start+w.maxStatements, len(statements))
+		chunk := statements[start:end]
+
+		started := time.Now()
+		err := w.writeChunk(ctx, chunk)
+		w.metrics.BatchWritten(ctx, w.jobName, started, err)
+		if err != nil {
+			w.logger.Error("Error executing batch chunk",
+				zap.Error(err),
+				zap.Int("statements", len(chunk)),
+			)
+			for _, statement := range chunk {
+				failed[statement.Key] = err
+			}
+		}
+	}
+
+	return failed
+}
+
+// writeChunk executes a chunk, in a logged batch when it adds events to the
+// outbox, which lives in other partitions
+func (w *batchWriter) writeChunk(ctx context.Context, chunk []batchStatement) (err error) {
+	batchType := w.batchType
+	for _, statement := range chunk {
+		if statement.Event != nil {
+			batchType = gocql.LoggedBatch
+			break
+		}
+	}
+
+	ctx, span := tracer.Start(ctx, "scylla.batch", trace.WithAttributes(
+		attribute.String("job.name", w.jobName),
+		attribute.Int("batch.statements", len(chunk)),
+		attribute.String("batch.type", batchTypeName(batchType)),
+		attribute.String("db.consistency", w.consistency.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		span.SetAttributes(attribute.Int("batch.attempts", attempt+1))
+		if attempt > 0 {
+			w.logger.Warn("Retrying batch chunk", zap.Int("attempt", attempt), zap.Error(err))
+			select {
+			case <-time.After(w.retryBackoff * time.Duration(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		batch := w.scylla.Session().NewBatch(batchType).WithContext(ctx)
+		batch.SetConsistency(w.consistency)
+		for _, statement := range chunk {
+			batch.Query(statement.Query, statement.Args...)
+			if statement.Event != nil {
+				if err := outbox.Add(batch, statement.Event.Subject, statement.Event.AggregateKey, statement.Event.Event); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = w.scylla.Session().ExecuteBatch(batch); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func batchTypeName(batchType gocql.BatchType) string {
+	if batchType == gocql.LoggedBatch {
+		return "logged"
+	}
+	return "unlogged"
+}
@@ -0,0 +1,205 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mashaghel/internal/database/scylla"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.uber.org/zap"
+)
+
+// The table names are formatted with the checkpoints table of the scanner
+const (
+	querySelectBucketCursor = `SELECT bucket, id FROM %s WHERE shard = ?;`
+	queryUpsertBucketCursor = `UPDATE %s SET bucket = ?, id = ?, updated_at = ? WHERE shard = ?;`
+)
+
+// bucketCursor is the position of a bucket scan in a shard, the bucket it is
+// in and the last row of the bucket it processed
+type bucketCursor struct {
+	Bucket time.Time
+	ID     gocql.UUID
+}
+
+// bucketScanner reads a table that is split into a partition per shard and
+// time bucket, and clustered by a TIMEUUID id, in the order its rows were
+// added. Its query has to select the rows of
+// `shard = ? AND bucket = ? AND id > ? AND id < ?` with a `LIMIT ?`. Every
+// shard moves forward from its position in the checkpoints table, and only
+// past the rows handle processed, so the rows are never deleted and expire
+// after retention instead. A scanner without a checkpoints table reads every
+// row of the last retention on every run.
+type bucketScanner[R any] struct {
+	scylla      scylla.ScyllaDB
+	logger      *zap.Logger
+	checkpoints string // Table of the positions, keyed by shard, if any
+	query       string
+	shards      int
+	bucketSize  time.Duration
+	retention   time.Duration
+	pageSize    int
+	// settle is how old a row has to be before it is read, so a write that is
+	// still in flight does not land behind the position of its shard
+	settle time.Duration
+	// scan reads the current row of the scanner and returns its id
+	scan func(scanner gocql.Scanner) (gocql.UUID, R, error)
+	// handle processes the rows of a page of a shard, in the order they were
+	// added, and returns how many of the first ones it processed
+	handle func(ctx context.Context, shard int, rows []R) (int, error)
+}
+
+// Run scans every shard on the worker pool up to the rows added settle ago
+func (s *bucketScanner[R]) Run(ctx context.Context, workers *Workers) error {
+	until := time.Now().Add(-s.settle)
+
+	var mu sync.Mutex
+	var errs []error
+	for shard := range s.shards {
+		if draining(workers) {
+			mu.Lock()
+			errs = append(errs, ErrInterrupted)
+			mu.Unlock()
+			break
+		}
+		if err := workers.Submit(func() {
+			if err := s.scanShard(ctx, shard, until, workers); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("shard %d: %w", shard, err))
+				mu.Unlock()
+			}
+		}); err != nil {
+			s.logger.Error("Failed to submit shard", zap.Error(err), zap.Int("shard", shard))
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	}
+	workers.Wait()
+
+	return errors.Join(errs...)
+}
+
+// scanShard reads a shard page by page and bucket by bucket. A page that was
+// not processed completely ends the shard for this run, the position stays
+// before the first row that was not processed.
+func (s *bucketScanner[R]) scanShard(ctx context.Context, shard int, until time.Time, workers *Workers) error {
+	cursor, err := s.cursor(ctx, shard, until)
+	if err != nil {
+		s.logger.Error("Failed to read checkpoint", zap.Error(err), zap.Int("shard", shard))
+		return err
+	}
+
+	last := until.UTC().Truncate(s.bucketSize)
+	for {
+		if draining(workers) {
+			return ErrInterrupted
+		}
+
+		ids, rows, err := s.page(ctx, shard, cursor, until)
+		if err != nil {
+			s.logger.Error("Failed to read page", zap.Error(err), zap.Int("shard", shard))
+			return err
+		}
+
+		processed := 0
+		if len(rows) > 0 {
+			processed, err = s.handle(ctx, shard, rows)
+			workers.Processed(processed)
+		}
+		if processed > 0 {
+			cursor.ID = ids[processed-1]
+			if saveErr := s.saveCursor(ctx, shard, cursor); saveErr != nil {
+				return errors.Join(err, saveErr)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if len(rows) == s.pageSize {
+			continue
+		}
+
+		if !cursor.Bucket.Before(last) {
+			return nil
+		}
+		cursor = bucketCursor{Bucket: cursor.Bucket.Add(s.bucketSize)}
+		if err := s.saveCursor(ctx, shard, cursor); err != nil {
+			return err
+		}
+	}
+}
+
+// cursor returns the position of a shard. A shard without one, or behind
+// the rows that are still kept, starts at the oldest bucket kept.
+func (s *bucketScanner[R]) cursor(ctx context.Context, shard int, until time.Time) (bucketCursor, error) {
+	oldest := bucketCursor{Bucket: until.Add(-s.retention).UTC().Truncate(s.bucketSize)}
+	if s.checkpoints == "" {
+		return oldest, nil
+	}
+
+	var cursor bucketCursor
+	err := s.scylla.Session().Query(fmt.Sprintf(querySelectBucketCursor, s.checkpoints), shard).
+		WithContext(ctx).
+		Scan(&cursor.Bucket, &cursor.ID)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return oldest, nil
+	}
+	if err != nil {
+		return bucketCursor{}, err
+	}
+	if cursor.Bucket.Before(oldest.Bucket) {
+		return oldest, nil
+	}
+	return cursor, nil
+}
+
+func (s *bucketScanner[R]) saveCursor(ctx context.Context, shard int, cursor bucketCursor) error {
+	if s.checkpoints == "" {
+		return nil
+	}
+
+	var id interface{}
+	if cursor.ID != (gocql.UUID{}) {
+		id = cursor.ID
+	}
+	err := s.scylla.Session().Query(fmt.Sprintf(queryUpsertBucketCursor, s.checkpoints), cursor.Bucket, id, time.Now(), shard).
+		WithContext(ctx).
+		Exec()
+	if err != nil {
+		s.logger.Error("Failed to save checkpoint", zap.Error(err), zap.Int("shard", shard), zap.Time("bucket", cursor.Bucket))
+	}
+	return err
+}
+
+// page returns the next rows of the bucket of cursor that were added before
+// until, in the order they were added, with their ids
+func (s *bucketScanner[R]) page(ctx context.Context, shard int, cursor bucketCursor, until time.Time) ([]gocql.UUID, []R, error) {
+	after := cursor.ID
+	if after == (gocql.UUID{}) {
+		after = gocql.MinTimeUUID(cursor.Bucket)
+	}
+	iter := s.scylla.Session().Query(s.query, shard, cursor.Bucket, after, gocql.MinTimeUUID(until), s.pageSize).
+		WithContext(ctx).
+		Iter()
+	scanner := iter.Scanner()
+
+	var ids []gocql.UUID
+	var rows []R
+	for scanner.Next() {
+		id, row, err := s.scan(scanner)
+		if err != nil {
+			iter.Close()
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return ids, rows, nil
+}
@@ -0,0 +1,198 @@
+package tasks
+
+import (
+	"context"
+	"mashaghel/internal/database/scylla"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.uber.org/zap"
+)
+
+const (
+	querySelectCheckpoints = `SELECT range_id, range_start, range_end, run_id, token, run_started_at, updated_at, finished FROM job_checkpoints WHERE job_name = ?;`
+	queryDeleteCheckpoints = `DELETE FROM job_checkpoints WHERE job_name = ?;`
+	queryUpsertCheckpoint  = `UPDATE job_checkpoints SET range_start = ?, range_end = ?, run_id = ?, token = ?, run_started_at = ?, updated_at = ?, finished = ? WHERE job_name = ? AND range_id = ?;`
+)
+
+// Checkpoint is the persisted progress of a job run over one range of the
+// token ring
+type Checkpoint struct {
+	JobName      string    `json:"job_name"`
+	RangeID      int       `json:"range_id"`
+	RangeStart   int64     `json:"range_start"`
+	RangeEnd     int64     `json:"range_end"`
+	RunID        string    `json:"run_id"`
+	Token        int64     `json:"token"`
+	RunStartedAt time.Time `json:"run_started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Finished     bool      `json:"finished"`
+}
+
+// checkpointStore keeps one checkpoint per job and token range in the
+// job_checkpoints table
+type checkpointStore struct {
+	scylla scylla.ScyllaDB
+}
+
+// Load returns the checkpoints of a job ordered by range
+func (s *checkpointStore) Load(ctx context.Context, jobName string) ([]Checkpoint, error) {
+	iter := s.scylla.Session().Query(querySelectCheckpoints, jobName).WithContext(ctx).Iter()
+
+	var checkpoints []Checkpoint
+	var runID gocql.UUID
+	cp := Checkpoint{JobName: jobName}
+	for iter.Scan(&cp.RangeID, &cp.RangeStart, &cp.RangeEnd, &runID, &cp.Token, &cp.RunStartedAt, &cp.UpdatedAt, &cp.Finished) {
+		cp.RunID = runID.String()
+		checkpoints = append(checkpoints, cp)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return checkpoints, nil
+}
+
+func (s *checkpointStore) Save(ctx context.Context, cp *Checkpoint) error {
+	runID, err := gocql.ParseUUID(cp.RunID)
+	if err != nil {
+		return err
+	}
+	cp.UpdatedAt = time.Now()
+
+	return s.scylla.Session().Query(
+		queryUpsertCheckpoint,
+		cp.RangeStart,
+		cp.RangeEnd,
+		runID,
+		cp.Token,
+		cp.RunStartedAt,
+		cp.UpdatedAt,
+		cp.Finished,
+		cp.JobName,
+		cp.RangeID,
+	).WithContext(ctx).Exec()
+}
+
+// Resume returns the checkpoints of the unfinished run of a job, or starts a
+// new run over the given ranges. A run is only resumed when it was split
+// into the same ranges.
+func (s *checkpointStore) Resume(ctx context.Context, jobName string, ranges []tokenRange) ([]Checkpoint, bool, error) {
+	checkpoints, err := s.Load(ctx, jobName)
+	if err != nil {
+		return nil, false, err
+	}
+	if resumable(checkpoints, ranges) {
+		return checkpoints, true, nil
+	}
+
+	if err := s.scylla.Session().Query(queryDeleteCheckpoints, jobName).WithContext(ctx).Exec(); err != nil {
+		return nil, false, err
+	}
+
+	checkpoints = newRunCheckpoints(jobName, ranges)
+	for i := range checkpoints {
+		if err := s.Save(ctx, &checkpoints[i]); err != nil {
+			return nil, false, err
+		}
+	}
+	return checkpoints, false, nil
+}
+
+// newRunCheckpoints returns the checkpoints of a new run that starts at the
+// beginning of every range
+func newRunCheckpoints(jobName string, ranges []tokenRange) []Checkpoint {
+	runID := gocql.TimeUUID().String()
+	startedAt := time.Now()
+	checkpoints := make([]Checkpoint, len(ranges))
+	for i, r := range ranges {
+		checkpoints[i] = Checkpoint{
+			JobName:      jobName,
+			RangeID:      i,
+			RangeStart:   r.Start,
+			RangeEnd:     r.End,
+			RunID:        runID,
+			Token:        r.Start,
+			RunStartedAt: startedAt,
+		}
+	}
+	return checkpoints
+}
+
+func resumable(checkpoints []Checkpoint, ranges []tokenRange) bool {
+	if len(checkpoints) != len(ranges) {
+		return false
+	}
+
+	unfinished := false
+	for i, cp := range checkpoints {
+		if cp.RangeID != i || cp.RangeStart != ranges[i].Start || cp.RangeEnd != ranges[i].End || cp.RunID != checkpoints[0].RunID {
+			return false
+		}
+		unfinished = unfinished || !cp.Finished
+	}
+	return unfinished
+}
+
+// rangeCheckpointer persists the progress of a single range scan, at most
+// once per interval while the scan is going. Without a store it only tracks
+// the position in memory.
+type rangeCheckpointer struct {
+	store      *checkpointStore
+	logger     *zap.Logger
+	checkpoint *Checkpoint
+	interval   time.Duration
+
+	mu       sync.Mutex
+	lastSave time.Time
+}
+
+func newRangeCheckpointer(store *checkpointStore, cp *Checkpoint, interval time.Duration, logger *zap.Logger) *rangeCheckpointer {
+	return &rangeCheckpointer{
+		store:      store,
+		logger:     logger,
+		checkpoint: cp,
+		interval:   interval,
+		lastSave:   time.Now(),
+	}
+}
+
+// Processed moves the checkpoint past a partition and saves it when it is due
+func (c *rangeCheckpointer) Processed(ctx context.Context, token int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkpoint.Token = token
+	if time.Since(c.lastSave) < c.interval {
+		return
+	}
+	c.save(ctx, false)
+}
+
+// Flush saves the current position. Once every range of a run is finished
+// the next run starts from the beginning of the token ring.
+func (c *rangeCheckpointer) Flush(ctx context.Context, finished bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.save(ctx, finished)
+}
+
+func (c *rangeCheckpointer) save(ctx context.Context, finished bool) {
+	c.checkpoint.Finished = finished
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Save(ctx, c.checkpoint); err != nil {
+		c.logger.Error("Failed to save checkpoint", zap.Error(err), zap.Int64("token", c.checkpoint.Token))
+		return
+	}
+	c.lastSave = time.Now()
+	c.logger.Info("Checkpoint saved",
+		zap.String("runID", c.checkpoint.RunID),
+		zap.Int("rangeID", c.checkpoint.RangeID),
+		zap.Int64("token", c.checkpoint.Token),
+		zap.Time("runStartedAt", c.checkpoint.RunStartedAt),
+		zap.Bool("finished", finished),
+	)
+}
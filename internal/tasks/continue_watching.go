@@ -0,0 +1,424 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/arango"
+	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/repositories/models"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arangodb/go-driver/v2/arangodb"
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const continueWatchingJobName = "continue_watching"
+
+const (
+	// defaultContinueWatchingInterval is used when the job has no interval or
+	// schedule of its own
+	defaultContinueWatchingInterval = time.Hour
+	defaultCompletionThreshold      = 0.9
+	defaultContinueWatchingMaxItems = 20
+	// defaultContinueWatchingHistory caps the ordered_watch entries read for
+	// a profile when watch_retention has no max_entries
+	defaultContinueWatchingHistory = 1000
+
+	// arangoKeysChunk caps the keys bound to a single Arango query
+	arangoKeysChunk = 100
+)
+
+func init() {
+	registerJob(continueWatchingJobName, newContinueWatchingJob)
+}
+
+// continueWatchingRecentScan is the name the checkpoints of the recent_watch
+// scan of the job are kept under
+const continueWatchingRecentScan = continueWatchingJobName + "_recent"
+
+const (
+	querySelectProfileRecentWatch    = `SELECT play_id FROM recent_watch WHERE profile_id = ? LIMIT 1;`
+	querySelectProfileOrderedWatches = `SELECT watched_at, play_id, duration FROM ordered_watch WHERE profile_id = ? LIMIT ?;`
+
+	querySelectContinueWatching = `SELECT watched_at FROM continue_watching WHERE profile_id = ?;`
+	queryInsertContinueWatching = `INSERT INTO continue_watching (profile_id, watched_at, play_id, duration, length) VALUES (?, ?, ?, ?, ?);`
+	queryDeleteContinueWatching = `DELETE FROM continue_watching WHERE profile_id = ? AND watched_at = ?;`
+
+	queryVideoLengths = `FOR v IN @@collection FILTER v._key IN @keys RETURN { key: v._key, length: v.length }`
+)
+
+// continueWatchingJob materializes the partially watched videos of every
+// profile into continue_watching. Watches stay in recent_watch until the
+// watch job moves them to ordered_watch, so a profile is rebuilt from both
+// tables: a scan of recent_watch rebuilds the profiles that watched something
+// lately, a scan of ordered_watch the rest.
+type continueWatchingJob struct {
+	scylla    scylla.ScyllaDB
+	logger    *zap.Logger
+	threshold float64
+	maxItems  int
+	history   int // Newest ordered_watch entries read for a recent profile
+	lengths   *videoLengths
+	batches   *batchWriter
+	metrics   *jobMetrics
+}
+
+// continueWatchingItem is a row of continue_watching
+type continueWatchingItem struct {
+	watchedAt gocql.UUID
+	playID    gocql.UUID
+	duration  int
+	length    int
+}
+
+func newContinueWatchingJob(t *task, _ config.JobConfig) (*Job, error) {
+	if t.arango == nil {
+		return nil, errors.New("continue_watching needs arango for the video lengths")
+	}
+
+	cfg := t.configs.TasksConfig.ContinueWatching
+	c := &continueWatchingJob{
+		scylla:    t.scylla,
+		logger:    t.logger.With(zap.String("task", continueWatchingJobName)),
+		threshold: cfg.CompletionThreshold,
+		maxItems:  cfg.MaxItems,
+		history:   t.configs.TasksConfig.WatchRetention.MaxEntries,
+		lengths:   &videoLengths{arango: t.arango},
+		metrics:   t.metrics,
+	}
+	if c.threshold == 0 {
+		c.threshold = defaultCompletionThreshold
+	}
+	if c.maxItems == 0 {
+		c.maxItems = defaultContinueWatchingMaxItems
+	}
+	if c.history == 0 {
+		c.history = defaultContinueWatchingHistory
+	}
+	batches, err := newBatchWriter(t.scylla, t.configs.TasksConfig.WatchBatch, continueWatchingJobName, t.metrics, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	c.batches = batches
+
+	recent := &ringScanner[playInfo]{
+		name:        continueWatchingRecentScan,
+		scylla:      t.scylla,
+		logger:      c.logger,
+		checkpoints: t.checkpoints,
+		configs:     t.ringScanConfig(),
+		query:       querySelectRecentWatches,
+		scan:        scanRecentWatch,
+		handle:      c.processRecentProfiles,
+	}
+	ordered := &ringScanner[orderedWatch]{
+		name:        continueWatchingJobName,
+		scylla:      t.scylla,
+		logger:      c.logger,
+		checkpoints: t.checkpoints,
+		configs:     t.ringScanConfig(),
+		query:       querySelectOrderedWatches,
+		scan:        scanOrderedWatch,
+		handle:      c.processProfiles,
+	}
+
+	return &Job{
+		Name:      continueWatchingJobName,
+		Interval:  defaultContinueWatchingInterval,
+		Exclusive: true,
+		Run: func(ctx context.Context, workers *Workers) error {
+			recentErr := recent.Run(ctx, workers)
+			if draining(workers) {
+				return errors.Join(recentErr, ErrInterrupted)
+			}
+			return errors.Join(recentErr, ordered.Run(ctx, workers))
+		},
+	}, nil
+}
+
+// processRecentProfiles rebuilds the continue_watching rows of the profiles
+// of a single recent_watch partition together with their ordered_watch history
+func (c *continueWatchingJob) processRecentProfiles(ctx context.Context, run scanRun, _ int64, rows []playInfo) error {
+	profiles := make(map[gocql.UUID][]orderedWatch)
+	for _, row := range rows {
+		profiles[row.profile_id] = append(profiles[row.profile_id], orderedWatch{
+			profileID: row.profile_id,
+			watchedAt: row.watchedAt,
+			playID:    row.play_id,
+			duration:  row.duration,
+		})
+	}
+
+	for profileID, recentRows := range profiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		history, err := c.orderedWatches(ctx, profileID)
+		if err != nil {
+			c.logger.Error("Failed to read ordered watches", zap.Error(err), zap.String("profileID", profileID.String()))
+			return err
+		}
+		if err := c.rebuild(ctx, run, profileID, append(history, recentRows...)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// processProfiles rebuilds the continue_watching rows of the profiles of a
+// single ordered_watch partition. Profiles that still have rows in
+// recent_watch are left to the recent_watch scan.
+func (c *continueWatchingJob) processProfiles(ctx context.Context, run scanRun, _ int64, rows []orderedWatch) error {
+	profiles := make(map[gocql.UUID][]orderedWatch)
+	for _, row := range rows {
+		profiles[row.profileID] = append(profiles[row.profileID], row)
+	}
+
+	for profileID, profileRows := range profiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		recent, err := c.hasRecentWatches(ctx, profileID)
+		if err != nil {
+			c.logger.Error("Failed to read recent watches", zap.Error(err), zap.String("profileID", profileID.String()))
+			return err
+		}
+		if recent {
+			continue
+		}
+		if err := c.rebuild(ctx, run, profileID, profileRows); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// rebuild replaces the continue_watching row of a profile with the in
+// progress plays of its watches. A failed write fails the partition, so the
+// checkpoint stays before it and the next run rebuilds the profile again.
+func (c *continueWatchingJob) rebuild(ctx context.Context, run scanRun, profileID gocql.UUID, rows []orderedWatch) error {
+	c.metrics.ProfilesScanned(ctx, continueWatchingJobName, 1)
+
+	keys := make([]string, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.playID.String())
+	}
+	lengths, err := c.lengths.Get(ctx, run.ID, keys)
+	if err != nil {
+		c.logger.Error("Failed to read video lengths", zap.Error(err))
+		return err
+	}
+
+	items := inProgressWatches(rows, lengths, c.threshold, c.maxItems)
+	if err := c.writeItems(ctx, profileID, items); err != nil {
+		c.logger.Error("Failed to update continue watching",
+			zap.Error(err),
+			zap.String("profileID", profileID.String()),
+		)
+		return err
+	}
+	return nil
+}
+
+// orderedWatches returns the newest ordered_watch entries of a profile, up to
+// the history limit of the job
+func (c *continueWatchingJob) orderedWatches(ctx context.Context, profileID gocql.UUID) ([]orderedWatch, error) {
+	iter := c.scylla.Session().Query(querySelectProfileOrderedWatches, profileID, c.history).
+		WithContext(ctx).
+		Consistency(gocql.One).
+		Iter()
+
+	var rows []orderedWatch
+	row := orderedWatch{profileID: profileID}
+	for iter.Scan(&row.watchedAt, &row.playID, &row.duration) {
+		rows = append(rows, row)
+		row = orderedWatch{profileID: profileID}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (c *continueWatchingJob) hasRecentWatches(ctx context.Context, profileID gocql.UUID) (bool, error) {
+	var playID gocql.UUID
+	err := c.scylla.Session().Query(querySelectProfileRecentWatch, profileID).
+		WithContext(ctx).
+		Consistency(gocql.One).
+		Scan(&playID)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// inProgressWatches returns the latest watch of every started play that is
+// below the completion threshold of its video, newest first and up to
+// maxItems. Plays without a known video length are left out.
+func inProgressWatches(rows []orderedWatch, lengths map[string]int, threshold float64, maxItems int) []continueWatchingItem {
+	latest := make(map[gocql.UUID]orderedWatch, len(rows))
+	for _, row := range rows {
+		if current, ok := latest[row.playID]; !ok || row.watchedAt.Time().After(current.watchedAt.Time()) {
+			latest[row.playID] = row
+		}
+	}
+
+	var items []continueWatchingItem
+	for _, row := range latest {
+		length := lengths[row.playID.String()]
+		if length <= 0 || row.duration <= 0 || float64(row.duration) >= threshold*float64(length) {
+			continue
+		}
+		items = append(items, continueWatchingItem{
+			watchedAt: row.watchedAt,
+			playID:    row.playID,
+			duration:  row.duration,
+			length:    length,
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].watchedAt.Time().After(items[j].watchedAt.Time())
+	})
+	if len(items) > maxItems {
+		items = items[:maxItems]
+	}
+	return items
+}
+
+// writeItems replaces the continue_watching row of a profile with items
+func (c *continueWatchingJob) writeItems(ctx context.Context, profileID gocql.UUID, items []continueWatchingItem) error {
+	existing := make(map[gocql.UUID]bool)
+	var watchedAt gocql.UUID
+	iter := c.scylla.Session().Query(querySelectContinueWatching, profileID).
+		WithContext(ctx).
+		Consistency(gocql.One).
+		Iter()
+	for iter.Scan(&watchedAt) {
+		existing[watchedAt] = true
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	var inserts, deletes []batchStatement
+	for _, item := range items {
+		delete(existing, item.watchedAt)
+		inserts = append(inserts, batchStatement{
+			Key:   item.watchedAt.String(),
+			Query: queryInsertContinueWatching,
+			Args:  []interface{}{profileID, item.watchedAt, item.playID, item.duration, item.length},
+		})
+	}
+	for watchedAt := range existing {
+		deletes = append(deletes, batchStatement{
+			Key:   watchedAt.String(),
+			Query: queryDeleteContinueWatching,
+			Args:  []interface{}{profileID, watchedAt},
+		})
+	}
+	if len(inserts) == 0 && len(deletes) == 0 {
+		return nil
+	}
+
+	failed := c.batches.Write(ctx, inserts)
+	for key, err := range c.batches.Write(ctx, deletes) {
+		failed[key] = err
+	}
+	trace.SpanFromContext(ctx).AddEvent("continue_watching.updated", trace.WithAttributes(
+		attribute.String("watch.profile_id", profileID.String()),
+		attribute.Int("continue_watching.items", len(inserts)),
+		attribute.Int("continue_watching.dropped", len(deletes)),
+		attribute.Int("continue_watching.failed", len(failed)),
+	))
+
+	var errs []error
+	seen := make(map[string]bool)
+	for _, err := range failed {
+		if !seen[err.Error()] {
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// videoLengths caches the lengths of the videos in Arango for the duration
+// of a run. Unknown videos are cached with a zero length.
+type videoLengths struct {
+	arango arango.ArangoDB
+
+	mu      sync.Mutex
+	runID   string
+	lengths map[string]int
+}
+
+// Get returns the lengths of the given video keys, querying Arango for the
+// ones not yet read in this run
+func (v *videoLengths) Get(ctx context.Context, runID string, keys []string) (map[string]int, error) {
+	v.mu.Lock()
+	if v.runID != runID {
+		v.runID = runID
+		v.lengths = make(map[string]int)
+	}
+	lengths := make(map[string]int, len(keys))
+	var missing []string
+	for _, key := range keys {
+		if length, ok := v.lengths[key]; ok {
+			lengths[key] = length
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	v.mu.Unlock()
+
+	for start := 0; start < len(missing); start += arangoKeysChunk {
+		chunk := missing[start:min(start+arangoKeysChunk, len(missing))]
+		read, err := v.query(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		v.mu.Lock()
+		for _, key := range chunk {
+			lengths[key] = read[key]
+			if v.runID == runID {
+				v.lengths[key] = read[key]
+			}
+		}
+		v.mu.Unlock()
+	}
+	return lengths, nil
+}
+
+func (v *videoLengths) query(ctx context.Context, keys []string) (map[string]int, error) {
+	cursor, err := v.arango.Database(ctx).Query(ctx, queryVideoLengths, &arangodb.QueryOptions{
+		BindVars: map[string]interface{}{
+			"@collection": models.VideosCollection,
+			"keys":        keys,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	lengths := make(map[string]int, len(keys))
+	for cursor.HasMore() {
+		var doc struct {
+			Key    string `json:"key"`
+			Length int    `json:"length"`
+		}
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			return nil, err
+		}
+		lengths[doc.Key] = doc.Length
+	}
+	return lengths, nil
+}
@@ -0,0 +1,58 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func Test_inProgressWatches(t *testing.T) {
+	now := time.Now()
+	watch := func(playID gocql.UUID, hoursAgo, duration int) orderedWatch {
+		return orderedWatch{
+			watchedAt: gocql.UUIDFromTime(now.Add(-time.Duration(hoursAgo) * time.Hour)),
+			playID:    playID,
+			duration:  duration,
+		}
+	}
+
+	started, rewatched, finished, unknown, other := gocql.TimeUUID(), gocql.TimeUUID(), gocql.TimeUUID(), gocql.TimeUUID(), gocql.TimeUUID()
+	lengths := map[string]int{
+		started.String():   1000,
+		rewatched.String(): 1000,
+		finished.String():  1000,
+		other.String():     1000,
+	}
+	rows := []orderedWatch{
+		watch(started, 1, 300),
+		watch(rewatched, 2, 950), // Latest watch of rewatched is finished
+		watch(finished, 3, 900),
+		watch(unknown, 4, 100),
+		watch(rewatched, 5, 100),
+		watch(other, 6, 10),
+	}
+
+	testCases := []struct {
+		name     string
+		maxItems int
+		want     []gocql.UUID
+	}{
+		{name: "all in progress", maxItems: 10, want: []gocql.UUID{started, other}},
+		{name: "capped", maxItems: 1, want: []gocql.UUID{started}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			items := inProgressWatches(rows, lengths, 0.9, tt.maxItems)
+			if len(items) != len(tt.want) {
+				t.Fatalf("inProgressWatches() returned %d items, want %d", len(items), len(tt.want))
+			}
+			for i, item := range items {
+				if item.playID != tt.want[i] {
+					t.Fatalf("inProgressWatches()[%d] = %v, want %v", i, item.playID, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"mashaghel/internal/database/scylla"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	queryInsertDeadLetter  = `INSERT INTO job_dead_letters (job_name, id, item_key, payload, error, attempts, created_at) VALUES (?, ?, ?, ?, ?, ?, ?);`
+	querySelectDeadLetters = `SELECT id, item_key, payload, error, attempts, created_at FROM job_dead_letters WHERE job_name = ? LIMIT ?;`
+	querySelectDeadLetter  = `SELECT id, item_key, payload, error, attempts, created_at FROM job_dead_letters WHERE job_name = ? AND id = ?;`
+	queryDeleteDeadLetter  = `DELETE FROM job_dead_letters WHERE job_name = ? AND id = ?;`
+)
+
+// DeadLetter is a work item of a job that failed every attempt of its retry
+// policy. The payload is whatever the job needs to replay the item.
+type DeadLetter struct {
+	JobName   string          `json:"job_name"`
+	ID        string          `json:"id"`
+	ItemKey   string          `json:"item_key"`
+	Payload   json.RawMessage `json:"payload"`
+	Error     string          `json:"error"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// deadLetterStore keeps the dead letters of every job in the
+// job_dead_letters table, newest first
+type deadLetterStore struct {
+	scylla scylla.ScyllaDB
+}
+
+func (s *deadLetterStore) Add(ctx context.Context, dl *DeadLetter) error {
+	id := gocql.TimeUUID()
+	dl.ID = id.String()
+	dl.CreatedAt = id.Time()
+
+	return s.scylla.Session().Query(
+		queryInsertDeadLetter,
+		dl.JobName,
+		id,
+		dl.ItemKey,
+		string(dl.Payload),
+		dl.Error,
+		dl.Attempts,
+		dl.CreatedAt,
+	).WithContext(ctx).Exec()
+}
+
+func (s *deadLetterStore) List(ctx context.Context, jobName string, limit int) ([]DeadLetter, error) {
+	iter := s.scylla.Session().Query(querySelectDeadLetters, jobName, limit).WithContext(ctx).Iter()
+
+	var deadLetters []DeadLetter
+	for {
+		dl, ok := scanDeadLetter(iter, jobName)
+		if !ok {
+			break
+		}
+		deadLetters = append(deadLetters, dl)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return deadLetters, nil
+}
+
+func (s *deadLetterStore) Get(ctx context.Context, jobName, id string) (*DeadLetter, error) {
+	uuid, err := gocql.ParseUUID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := s.scylla.Session().Query(querySelectDeadLetter, jobName, uuid).WithContext(ctx).Iter()
+	dl, ok := scanDeadLetter(iter, jobName)
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	return &dl, nil
+}
+
+func (s *deadLetterStore) Delete(ctx context.Context, jobName, id string) error {
+	uuid, err := gocql.ParseUUID(id)
+	if err != nil {
+		return err
+	}
+	return s.scylla.Session().Query(queryDeleteDeadLetter, jobName, uuid).WithContext(ctx).Exec()
+}
+
+func scanDeadLetter(iter *gocql.Iter, jobName string) (DeadLetter, bool) {
+	var id gocql.UUID
+	var payload string
+	dl := DeadLetter{JobName: jobName}
+	if !iter.Scan(&id, &dl.ItemKey, &payload, &dl.Error, &dl.Attempts, &dl.CreatedAt) {
+		return dl, false
+	}
+	dl.ID = id.String()
+	dl.Payload = json.RawMessage(payload)
+	return dl, true
+}
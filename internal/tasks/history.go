@@ -0,0 +1,97 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/repositories/models"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const defaultRunHistoryTTL = 30 // In days
+
+const (
+	queryInsertJobRun = `INSERT INTO job_runs (job_name, run_id, host, started_at, status) VALUES (?, ?, ?, ?, ?) USING TTL ?;`
+	queryFinishJobRun = `UPDATE job_runs USING TTL ? SET finished_at = ?, status = ?, rows_processed = ?, error = ? WHERE job_name = ? AND run_id = ?;`
+)
+
+// runHistory records every run of a job in the job_runs table
+type runHistory struct {
+	scylla scylla.ScyllaDB
+	host   string
+	ttl    int
+}
+
+func newRunHistory(scyllaDB scylla.ScyllaDB, ttlDays int) *runHistory {
+	host, _ := os.Hostname()
+	if ttlDays == 0 {
+		ttlDays = defaultRunHistoryTTL
+	}
+	return &runHistory{
+		scylla: scyllaDB,
+		host:   host,
+		ttl:    int((time.Duration(ttlDays) * 24 * time.Hour).Seconds()),
+	}
+}
+
+// Start records a running job run
+func (h *runHistory) Start(ctx context.Context, jobName string) (*models.JobRun, error) {
+	runID := gocql.TimeUUID()
+	run := &models.JobRun{
+		JobName:   jobName,
+		RunID:     runID.String(),
+		Host:      h.host,
+		StartedAt: runID.Time(),
+		Status:    models.JobRunRunning,
+	}
+
+	err := h.scylla.Session().Query(queryInsertJobRun, jobName, runID, run.Host, run.StartedAt, run.Status, h.ttl).
+		WithContext(ctx).
+		Exec()
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// runStatus returns the status of a run that returned err
+func runStatus(err error) string {
+	switch {
+	case err == nil:
+		return models.JobRunSucceeded
+	case errors.Is(err, ErrInterrupted):
+		return models.JobRunInterrupted
+	default:
+		return models.JobRunFailed
+	}
+}
+
+// Finish records the outcome of a job run
+func (h *runHistory) Finish(ctx context.Context, run *models.JobRun, rowsProcessed int64, runErr error) error {
+	runID, err := gocql.ParseUUID(run.RunID)
+	if err != nil {
+		return err
+	}
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.RowsProcessed = rowsProcessed
+	run.Status = runStatus(runErr)
+	if run.Status == models.JobRunFailed {
+		run.Error = runErr.Error()
+	}
+
+	return h.scylla.Session().Query(
+		queryFinishJobRun,
+		h.ttl,
+		finishedAt,
+		run.Status,
+		rowsProcessed,
+		run.Error,
+		run.JobName,
+		runID,
+	).WithContext(ctx).Exec()
+}
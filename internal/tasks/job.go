@@ -0,0 +1,110 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+)
+
+// Job is a background job that is scheduled by the task manager
+type Job struct {
+	Name string
+	// Interval between two runs of the job when no cron schedule is configured
+	Interval time.Duration
+	Schedule Schedule
+	// Jitter delays every run by a random duration up to its value
+	Jitter time.Duration
+	// AllowOverlap starts a run even when the previous one is still going,
+	// otherwise that run is skipped
+	AllowOverlap bool
+	// Exclusive jobs only run on the replica holding the job's leader lock
+	// when the leader lock is enabled
+	Exclusive bool
+	// Concurrency caps the number of work items the job may have on the
+	// shared worker pool at the same time
+	Concurrency int
+	Run         func(ctx context.Context, workers *Workers) error
+	// Replay runs a dead-lettered work item of the job again, jobs without
+	// dead letters leave it nil
+	Replay func(ctx context.Context, payload json.RawMessage) error
+	// DryRun reports what a run of the job would write without writing
+	// anything, jobs without a dry run leave it nil
+	DryRun func(ctx context.Context, workers *Workers) (any, error)
+
+	// trigger holds a manually triggered run until the scheduler picks it up
+	trigger chan struct{}
+	running atomic.Int32
+}
+
+// start marks a run of the job as started, it fails when the previous run is
+// still going and the job does not allow overlapping runs
+func (j *Job) start() bool {
+	if j.AllowOverlap {
+		j.running.Add(1)
+		return true
+	}
+	return j.running.CompareAndSwap(0, 1)
+}
+
+// Workers hands the work of a single job run to the shared worker pool
+// while respecting the concurrency limit of the job
+type Workers struct {
+	pool *ants.Pool
+	sem  chan struct{}
+	wg   sync.WaitGroup
+	// processed counts the rows the run processed, it is recorded in the
+	// run history
+	processed atomic.Int64
+	draining  <-chan struct{}
+}
+
+func newWorkers(pool *ants.Pool, limit int, draining <-chan struct{}) *Workers {
+	if limit <= 0 {
+		limit = pool.Cap()
+	}
+	return &Workers{
+		pool:     pool,
+		sem:      make(chan struct{}, limit),
+		draining: draining,
+	}
+}
+
+// Submit blocks until the job has a free slot and then runs fn on the pool
+func (w *Workers) Submit(fn func()) error {
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+
+	err := w.pool.Submit(func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		fn()
+	})
+	if err != nil {
+		<-w.sem
+		w.wg.Done()
+		return err
+	}
+	return nil
+}
+
+// Wait blocks until every submitted work item of the job is done
+func (w *Workers) Wait() {
+	w.wg.Wait()
+}
+
+// Processed adds n rows to the rows processed by the run
+func (w *Workers) Processed(n int) {
+	w.processed.Add(int64(n))
+}
+
+// Draining is closed when the task manager is stopping. Jobs finish the work
+// item at hand and return instead of starting new ones.
+func (w *Workers) Draining() <-chan struct{} {
+	return w.draining
+}
@@ -0,0 +1,168 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"mashaghel/internal/config"
+	"mashaghel/internal/producers"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const defaultLeaderKeyPrefix = "mashaghel:jobs:leader"
+
+var (
+	// renewLeaseScript extends the lease only if this replica still owns it
+	renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	// releaseLeaseScript removes the lease only if this replica still owns it
+	releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// leaderElector hands out per job leases so that a single replica runs an
+// exclusive job at a time. A lease that is not renewed expires after its ttl,
+// which lets another replica take over when the holder dies.
+type leaderElector struct {
+	client        redis.UniversalClient
+	logger        *zap.Logger
+	owner         string
+	keyPrefix     string
+	ttl           time.Duration
+	renewInterval time.Duration
+}
+
+func newLeaderElector(redisClient producers.RedisClient, cfg config.LeaderLockConfig, logger *zap.Logger) (*leaderElector, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("leader lock is enabled but no redis client is provided")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	keyPrefix := cfg.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = defaultLeaderKeyPrefix
+	}
+
+	ttl := time.Duration(cfg.TTL) * time.Second
+	renewInterval := time.Duration(cfg.RenewInterval) * time.Second
+	if renewInterval <= 0 {
+		renewInterval = ttl / 3
+	}
+	if renewInterval >= ttl {
+		return nil, fmt.Errorf("leader lock renew interval %s must be shorter than its ttl %s", renewInterval, ttl)
+	}
+
+	owner := hostname + "-" + uuid.NewString()
+
+	return &leaderElector{
+		client:        redisClient.RedisStorage().Conn(),
+		logger:        logger.With(zap.String("owner", owner)),
+		owner:         owner,
+		keyPrefix:     keyPrefix,
+		ttl:           ttl,
+		renewInterval: renewInterval,
+	}, nil
+}
+
+// lease is a held leader lock. Its context is cancelled as soon as the lease
+// is lost or released.
+type lease struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	key    string
+}
+
+// acquire tries to become the leader of a job. It returns a nil lease
+// without error when another replica is the leader.
+func (l *leaderElector) acquire(ctx context.Context, jobName string) (*lease, error) {
+	key := l.keyPrefix + ":" + jobName
+
+	ok, err := l.client.SetNX(ctx, key, l.owner, l.ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire leader lock %s: %w", key, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	ls := &lease{
+		ctx:    leaseCtx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		key:    key,
+	}
+	go l.keepAlive(ls)
+
+	l.logger.Info("Acquired leader lock", zap.String("key", key))
+	return ls, nil
+}
+
+// keepAlive renews the lease until it is released. The lease is given up
+// when it is taken by another replica or could not be renewed before it
+// expired.
+func (l *leaderElector) keepAlive(ls *lease) {
+	defer close(ls.done)
+
+	ticker := time.NewTicker(l.renewInterval)
+	defer ticker.Stop()
+
+	expiresAt := time.Now().Add(l.ttl)
+	for {
+		select {
+		case <-ls.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed, err := renewLeaseScript.Run(ls.ctx, l.client, []string{ls.key}, l.owner, l.ttl.Milliseconds()).Int()
+		switch {
+		case ls.ctx.Err() != nil:
+			return
+		case err != nil && time.Now().Before(expiresAt):
+			l.logger.Warn("Failed to renew leader lock, retrying", zap.String("key", ls.key), zap.Error(err))
+		case err != nil:
+			l.logger.Error("Leader lock expired before it could be renewed", zap.String("key", ls.key), zap.Error(err))
+			ls.cancel()
+			return
+		case renewed == 0:
+			l.logger.Error("Leader lock was lost to another replica", zap.String("key", ls.key))
+			ls.cancel()
+			return
+		default:
+			expiresAt = time.Now().Add(l.ttl)
+		}
+	}
+}
+
+// release stops renewing the lease and removes it so that another replica
+// does not have to wait for it to expire
+func (l *leaderElector) release(ls *lease) {
+	ls.cancel()
+	<-ls.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := releaseLeaseScript.Run(ctx, l.client, []string{ls.key}, l.owner).Err(); err != nil {
+		l.logger.Error("Failed to release leader lock", zap.String("key", ls.key), zap.Error(err))
+		return
+	}
+	l.logger.Info("Released leader lock", zap.String("key", ls.key))
+}
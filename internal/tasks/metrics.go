@@ -0,0 +1,166 @@
+package tasks
+
+import (
+	"context"
+	"mashaghel/internal/repositories/models"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "mashaghel/internal/tasks"
+
+// jobMetrics are the OTel instruments of the task manager. They are created
+// on the global meter provider and cost nothing until it is configured.
+type jobMetrics struct {
+	profilesScanned metric.Int64Counter
+	rowsMoved       metric.Int64Counter
+	rowsDeleted     metric.Int64Counter
+	batchFailures   metric.Int64Counter
+	batchDuration   metric.Float64Histogram
+	runDuration     metric.Float64Histogram
+
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time
+}
+
+func newJobMetrics(pool func() *ants.Pool) (*jobMetrics, error) {
+	meter := otel.Meter(meterName)
+	m := &jobMetrics{lastSuccess: make(map[string]time.Time)}
+
+	var err error
+	if m.profilesScanned, err = meter.Int64Counter("tasks.profiles.scanned",
+		metric.WithDescription("Profiles scanned by background jobs"),
+		metric.WithUnit("{profile}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.rowsMoved, err = meter.Int64Counter("tasks.rows.moved",
+		metric.WithDescription("Rows moved out of recent_watch"),
+		metric.WithUnit("{row}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.rowsDeleted, err = meter.Int64Counter("tasks.rows.deleted",
+		metric.WithDescription("Rows deleted by retention jobs"),
+		metric.WithUnit("{row}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.batchFailures, err = meter.Int64Counter("tasks.batch.failures",
+		metric.WithDescription("Batch chunks that failed every retry"),
+		metric.WithUnit("{batch}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.batchDuration, err = meter.Float64Histogram("tasks.batch.duration",
+		metric.WithDescription("Latency of a batch chunk including its retries"),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if m.runDuration, err = meter.Float64Histogram("tasks.run.duration",
+		metric.WithDescription("Duration of a job run"),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+
+	poolWorkers, err := meter.Int64ObservableGauge("tasks.pool.workers",
+		metric.WithDescription("Workers of the shared worker pool by state"),
+		metric.WithUnit("{worker}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	lastSuccess, err := meter.Int64ObservableGauge("tasks.run.last_success",
+		metric.WithDescription("Unix time of the last successful run of a job"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		if p := pool(); p != nil {
+			o.ObserveInt64(poolWorkers, int64(p.Running()), metric.WithAttributes(attribute.String("state", "running")))
+			o.ObserveInt64(poolWorkers, int64(p.Free()), metric.WithAttributes(attribute.String("state", "free")))
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for job, at := range m.lastSuccess {
+			o.ObserveInt64(lastSuccess, at.Unix(), metric.WithAttributes(jobAttr(job)))
+		}
+		return nil
+	}, poolWorkers, lastSuccess)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func jobAttr(jobName string) attribute.KeyValue {
+	return attribute.String("job", jobName)
+}
+
+// The recording methods do nothing on a nil *jobMetrics, like in dry runs
+
+// ProfilesScanned counts profiles a job looked at
+func (m *jobMetrics) ProfilesScanned(ctx context.Context, jobName string, n int) {
+	if m == nil {
+		return
+	}
+	m.profilesScanned.Add(ctx, int64(n), metric.WithAttributes(jobAttr(jobName)))
+}
+
+// RowsMoved counts rows a job moved out of their table
+func (m *jobMetrics) RowsMoved(ctx context.Context, jobName string, n int) {
+	if m == nil {
+		return
+	}
+	m.rowsMoved.Add(ctx, int64(n), metric.WithAttributes(jobAttr(jobName)))
+}
+
+// RowsDeleted counts rows a job deleted
+func (m *jobMetrics) RowsDeleted(ctx context.Context, jobName string, n int) {
+	if m == nil {
+		return
+	}
+	m.rowsDeleted.Add(ctx, int64(n), metric.WithAttributes(jobAttr(jobName)))
+}
+
+// BatchWritten records the latency of a batch chunk and counts it when it
+// failed
+func (m *jobMetrics) BatchWritten(ctx context.Context, jobName string, started time.Time, err error) {
+	if m == nil {
+		return
+	}
+	attrs := metric.WithAttributes(jobAttr(jobName), attribute.Bool("failed", err != nil))
+	m.batchDuration.Record(ctx, time.Since(started).Seconds(), attrs)
+	if err != nil {
+		m.batchFailures.Add(ctx, 1, metric.WithAttributes(jobAttr(jobName)))
+	}
+}
+
+// RunFinished records the duration and outcome of a job run
+func (m *jobMetrics) RunFinished(jobName string, started time.Time, status string) {
+	if m == nil {
+		return
+	}
+	m.runDuration.Record(context.Background(), time.Since(started).Seconds(),
+		metric.WithAttributes(jobAttr(jobName), attribute.String("status", status)),
+	)
+	if status != models.JobRunSucceeded {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSuccess[jobName] = time.Now()
+}
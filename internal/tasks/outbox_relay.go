@@ -0,0 +1,349 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/arango"
+	natsHelper "mashaghel/internal/helper/nats"
+	"mashaghel/internal/outbox"
+	"time"
+
+	"github.com/arangodb/go-driver/v2/arangodb"
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// OutboxRelayJobName is the name of the job publishing the outbox
+const OutboxRelayJobName = "outbox_relay"
+
+const (
+	// defaultOutboxRelayInterval is used when the job has no interval or
+	// schedule of its own
+	defaultOutboxRelayInterval  = 5 * time.Second
+	defaultOutboxRelayBatchSize = 500
+	// defaultOutboxRelaySettle is how old an event has to be before it is
+	// relayed
+	defaultOutboxRelaySettle = 10 * time.Second
+)
+
+func init() {
+	registerJob(OutboxRelayJobName, newOutboxRelayJob)
+}
+
+const querySelectOutbox = `SELECT id, shard, bucket, aggregate_key, subject, event FROM outbox_events WHERE shard = ? AND bucket = ? AND id > ? AND id < ? LIMIT ?;`
+
+const (
+	querySelectArangoOutbox = `FOR e IN @@collection FILTER e.created_at < @before SORT e.created_at, e._key LIMIT @limit RETURN e`
+	queryRemoveArangoOutbox = `FOR key IN @keys REMOVE key IN @@collection OPTIONS { ignoreErrors: true }`
+)
+
+// outboxEntry is a row of the outbox
+type outboxEntry struct {
+	Shard        int        `json:"shard"`
+	Bucket       time.Time  `json:"bucket"`
+	ID           gocql.UUID `json:"id"`
+	AggregateKey string     `json:"aggregate_key"`
+	Subject      string     `json:"subject"`
+	Event        []byte     `json:"event"`
+}
+
+// outboxRelayJob publishes the events of the outbox to NATS. It reads every
+// shard forward from its position in outbox_checkpoints, bucket by bucket,
+// and moves the position past the events the stream acked; the events
+// expire rather than being deleted. The events of the Arango outbox are
+// removed once they are acked. The events of an aggregate key are
+// published one at a time, the next one only after the previous one is
+// acked, so they reach the stream in order.
+type outboxRelayJob struct {
+	nats        natsHelper.NatsConnection
+	arango      arango.ArangoDB
+	logger      *zap.Logger
+	deadLetters *deadLetterStore
+	batchSize   int
+	settle      time.Duration
+}
+
+func newOutboxRelayJob(t *task, _ config.JobConfig) (*Job, error) {
+	if t.nats == nil {
+		return nil, errors.New("outbox_relay needs nats to publish the events")
+	}
+
+	cfg := t.configs.TasksConfig.OutboxRelay
+	r := &outboxRelayJob{
+		nats:        t.nats,
+		arango:      t.arango,
+		logger:      t.logger.With(zap.String("task", OutboxRelayJobName)),
+		deadLetters: t.deadLetters,
+		batchSize:   cfg.BatchSize,
+		settle:      time.Duration(cfg.Settle) * time.Second,
+	}
+	if r.batchSize == 0 {
+		r.batchSize = defaultOutboxRelayBatchSize
+	}
+	if r.settle == 0 {
+		r.settle = defaultOutboxRelaySettle
+	}
+	scanner := &bucketScanner[outboxEntry]{
+		scylla:      t.scylla,
+		logger:      r.logger,
+		checkpoints: "outbox_checkpoints",
+		query:       querySelectOutbox,
+		shards:      outbox.Shards,
+		bucketSize:  outbox.BucketSize,
+		retention:   outbox.Retention,
+		pageSize:    r.batchSize,
+		settle:      r.settle,
+		scan:        scanOutboxEntry,
+		handle: func(ctx context.Context, _ int, entries []outboxEntry) (int, error) {
+			return r.relay(ctx, entries)
+		},
+	}
+
+	return &Job{
+		Name:      OutboxRelayJobName,
+		Interval:  defaultOutboxRelayInterval,
+		Exclusive: true,
+		Run: func(ctx context.Context, workers *Workers) error {
+			return errors.Join(scanner.Run(ctx, workers), r.relayArango(ctx))
+		},
+		Replay: r.replay,
+	}, nil
+}
+
+func scanOutboxEntry(scanner gocql.Scanner) (gocql.UUID, outboxEntry, error) {
+	var entry outboxEntry
+	err := scanner.Scan(&entry.ID, &entry.Shard, &entry.Bucket, &entry.AggregateKey, &entry.Subject, &entry.Event)
+	return entry.ID, entry, err
+}
+
+// relayArango publishes the Arango outbox page by page, oldest first, and
+// removes the events the stream acked. Like the Scylla outbox, only events
+// older than the settle time are relayed.
+func (r *outboxRelayJob) relayArango(ctx context.Context) error {
+	if r.arango == nil {
+		return nil
+	}
+
+	for {
+		documents, err := r.arangoOutbox(ctx)
+		if err != nil {
+			return err
+		}
+		if len(documents) == 0 {
+			return nil
+		}
+
+		entries := make([]outboxEntry, 0, len(documents))
+		for _, document := range documents {
+			entries = append(entries, arangoOutboxEntry(document))
+		}
+		relayed, relayErr := r.relay(ctx, entries)
+
+		keys := make([]string, 0, relayed)
+		for _, document := range documents[:relayed] {
+			keys = append(keys, document.Key)
+		}
+		if err := r.removeArangoOutbox(ctx, keys); err != nil {
+			return errors.Join(relayErr, err)
+		}
+		if relayErr != nil || len(documents) < r.batchSize {
+			return relayErr
+		}
+	}
+}
+
+func (r *outboxRelayJob) arangoOutbox(ctx context.Context) ([]outbox.Document, error) {
+	cursor, err := r.arango.Database(ctx).Query(ctx, querySelectArangoOutbox, &arangodb.QueryOptions{
+		BindVars: map[string]interface{}{
+			"@collection": outbox.Collection,
+			"before":      time.Now().Add(-r.settle).UnixMicro(),
+			"limit":       r.batchSize,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var documents []outbox.Document
+	for cursor.HasMore() {
+		var document outbox.Document
+		if _, err := cursor.ReadDocument(ctx, &document); err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+func (r *outboxRelayJob) removeArangoOutbox(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	cursor, err := r.arango.Database(ctx).Query(ctx, queryRemoveArangoOutbox, &arangodb.QueryOptions{
+		BindVars: map[string]interface{}{
+			"@collection": outbox.Collection,
+			"keys":        keys,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
+
+// arangoOutboxEntry returns the outbox entry of an Arango outbox document,
+// whose key is the ID of its event
+func arangoOutboxEntry(document outbox.Document) outboxEntry {
+	id, _ := gocql.ParseUUID(document.Key)
+	return outboxEntry{
+		ID:           id,
+		AggregateKey: document.AggregateKey,
+		Subject:      document.Subject,
+		Event:        []byte(document.Event),
+	}
+}
+
+// relay publishes the entries of a shard, which are ordered by when they were
+// added, and returns how many of the first entries were delivered. Every
+// round publishes the next entry of every aggregate and waits for the acks;
+// an aggregate whose entry failed is left for the next run. The entries of
+// other aggregates delivered after the first failed one are published again
+// by the next run, and dropped by the stream as duplicates of their event ID.
+func (r *outboxRelayJob) relay(ctx context.Context, entries []outboxEntry) (int, error) {
+	queues := outboxQueues(entries)
+	delivered := make([]bool, len(entries))
+
+	var errs []error
+	for len(queues) > 0 {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		heads := make([]outboxEntry, len(queues))
+		for i, queue := range queues {
+			heads[i] = entries[queue[0]]
+		}
+		failed := r.publish(ctx, heads)
+
+		next := queues[:0]
+		for i, queue := range queues {
+			if err, ok := failed[i]; ok {
+				errs = append(errs, err)
+				continue
+			}
+			delivered[queue[0]] = true
+			if len(queue) > 1 {
+				next = append(next, queue[1:])
+			}
+		}
+		queues = next
+	}
+
+	relayed := 0
+	for relayed < len(delivered) && delivered[relayed] {
+		relayed++
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("outbox.relayed", trace.WithAttributes(
+		attribute.Int("outbox.entries", len(entries)),
+		attribute.Int("outbox.delivered", relayed),
+	))
+	return relayed, errors.Join(errs...)
+}
+
+// outboxQueues splits the entries of a shard into the indexes of the entries
+// of every aggregate, keeping their order
+func outboxQueues(entries []outboxEntry) [][]int {
+	var queues [][]int
+	positions := make(map[string]int)
+	for i, entry := range entries {
+		position, ok := positions[entry.AggregateKey]
+		if !ok {
+			position = len(queues)
+			positions[entry.AggregateKey] = position
+			queues = append(queues, nil)
+		}
+		queues[position] = append(queues[position], i)
+	}
+	return queues
+}
+
+// publish publishes the entries and waits for their acks. It returns the
+// errors of the entries that were not acked by their index. An entry whose
+// event is not valid is dead-lettered and counts as delivered, it would
+// block its aggregate forever.
+func (r *outboxRelayJob) publish(ctx context.Context, entries []outboxEntry) map[int]error {
+	failed := make(map[int]error)
+	publications := make(map[int]*natsHelper.Publication, len(entries))
+	for i, entry := range entries {
+		event, err := natsHelper.DecodeEvent(entry.Event, natsHelper.EncodingJSON)
+		if err != nil {
+			if err := r.deadLetter(ctx, entry, err); err != nil {
+				failed[i] = err
+			}
+			continue
+		}
+		publication, err := r.nats.PublishEvent(ctx, entry.Subject, event)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		publications[i] = publication
+	}
+
+	for i, publication := range publications {
+		if _, err := publication.Wait(ctx); err != nil {
+			r.logger.Warn("Outbox event was not acked",
+				zap.Error(err),
+				zap.String("aggregateKey", entries[i].AggregateKey),
+				zap.String("id", entries[i].ID.String()),
+			)
+			failed[i] = err
+		}
+	}
+	return failed
+}
+
+func (r *outboxRelayJob) deadLetter(ctx context.Context, entry outboxEntry, err error) error {
+	r.logger.Error("Invalid outbox event, dead-lettering it",
+		zap.Error(err),
+		zap.String("aggregateKey", entry.AggregateKey),
+		zap.String("id", entry.ID.String()),
+	)
+	payload, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return r.deadLetters.Add(ctx, &DeadLetter{
+		JobName:  OutboxRelayJobName,
+		ItemKey:  entry.AggregateKey,
+		Payload:  payload,
+		Error:    err.Error(),
+		Attempts: 1,
+	})
+}
+
+// replay publishes a dead-lettered outbox event, e.g. once its type is
+// registered, and waits for its ack
+func (r *outboxRelayJob) replay(ctx context.Context, payload json.RawMessage) error {
+	var entry outboxEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return err
+	}
+	event, err := natsHelper.DecodeEvent(entry.Event, natsHelper.EncodingJSON)
+	if err != nil {
+		return err
+	}
+	publication, err := r.nats.PublishEvent(ctx, entry.Subject, event)
+	if err != nil {
+		return err
+	}
+	_, err = publication.Wait(ctx)
+	return err
+}
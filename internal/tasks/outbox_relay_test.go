@@ -0,0 +1,141 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	natsHelper "mashaghel/internal/helper/nats"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/gocql/gocql"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// fakeNats acks every published event, except the events of failing
+type fakeNats struct {
+	natsHelper.NatsConnection
+
+	mu        sync.Mutex
+	failing   map[string]bool
+	published []*natsHelper.Event
+	subjects  []string
+}
+
+func (f *fakeNats) PublishEvent(_ context.Context, subject string, event *natsHelper.Event) (*natsHelper.Publication, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing[event.ID] {
+		return natsHelper.NewPublication(subject, event.ID, nil, errors.New("no ack")), nil
+	}
+	f.published = append(f.published, event)
+	f.subjects = append(f.subjects, subject)
+	return natsHelper.NewPublication(subject, event.ID, &nats.PubAck{Stream: "test"}, nil), nil
+}
+
+func Test_outboxQueues(t *testing.T) {
+	a1 := outboxEntry{AggregateKey: "a", ID: gocql.TimeUUID()}
+	b1 := outboxEntry{AggregateKey: "b", ID: gocql.TimeUUID()}
+	a2 := outboxEntry{AggregateKey: "a", ID: gocql.TimeUUID()}
+	c1 := outboxEntry{AggregateKey: "c", ID: gocql.TimeUUID()}
+	c2 := outboxEntry{AggregateKey: "c", ID: gocql.TimeUUID()}
+
+	testCases := []struct {
+		name    string
+		entries []outboxEntry
+		want    [][]int
+	}{
+		{name: "empty"},
+		{name: "single aggregate", entries: []outboxEntry{a1, a2}, want: [][]int{{0, 1}}},
+		{
+			name:    "interleaved aggregates",
+			entries: []outboxEntry{a1, b1, a2, c1, c2},
+			want:    [][]int{{0, 2}, {1}, {3, 4}},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outboxQueues(tt.entries); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("outboxQueues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_outboxRelayJob_relay(t *testing.T) {
+	entry := func(aggregateKey string) (outboxEntry, *natsHelper.Event) {
+		event := natsHelper.NewEvent(context.Background(), "outbox.relay.test", 1, &outboxTestPayload{})
+		data, err := natsHelper.EncodeEvent(event, natsHelper.EncodingJSON)
+		if err != nil {
+			t.Fatalf("EncodeEvent() error = %v", err)
+		}
+		return outboxEntry{AggregateKey: aggregateKey, ID: gocql.TimeUUID(), Subject: "test", Event: data}, event
+	}
+	a1, a1Event := entry("a")
+	b1, b1Event := entry("b")
+	a2, a2Event := entry("a")
+	b2, b2Event := entry("b")
+	a3, a3Event := entry("a")
+
+	testCases := []struct {
+		name          string
+		failing       []*natsHelper.Event
+		wantDelivered int
+		wantPublished []*natsHelper.Event
+	}{
+		{name: "all acked", wantDelivered: 5, wantPublished: []*natsHelper.Event{a1Event, b1Event, a2Event, b2Event, a3Event}},
+		{
+			// b2 is not published after b1 failed, the acked a events after
+			// b1 are published again by the next run
+			name:          "failed aggregate",
+			failing:       []*natsHelper.Event{b1Event},
+			wantDelivered: 1,
+			wantPublished: []*natsHelper.Event{a1Event, a2Event, a3Event},
+		},
+		{
+			name:          "failed first entry",
+			failing:       []*natsHelper.Event{a1Event},
+			wantDelivered: 0,
+			wantPublished: []*natsHelper.Event{b1Event, b2Event},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeNats{failing: make(map[string]bool)}
+			for _, event := range tt.failing {
+				conn.failing[event.ID] = true
+			}
+			r := &outboxRelayJob{nats: conn, logger: zap.NewNop()}
+
+			delivered, err := r.relay(context.Background(), []outboxEntry{a1, b1, a2, b2, a3})
+			if (err != nil) != (len(tt.failing) > 0) {
+				t.Fatalf("relay() error = %v", err)
+			}
+			if delivered != tt.wantDelivered {
+				t.Fatalf("relay() delivered %d, want %d", delivered, tt.wantDelivered)
+			}
+
+			var published, want []string
+			for _, event := range conn.published {
+				published = append(published, event.ID)
+			}
+			for _, event := range tt.wantPublished {
+				want = append(want, event.ID)
+			}
+			if !reflect.DeepEqual(published, want) {
+				t.Fatalf("relay() published %v, want %v", published, want)
+			}
+		})
+	}
+}
+
+type outboxTestPayload struct {
+	Name string `json:"name"`
+}
+
+func init() {
+	natsHelper.RegisterEvent("outbox.relay.test", 1, func() interface{} { return &outboxTestPayload{} })
+}
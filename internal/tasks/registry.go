@@ -0,0 +1,49 @@
+package tasks
+
+import (
+	"fmt"
+	"mashaghel/internal/config"
+	"sort"
+)
+
+// jobFactory builds a job from the task manager dependencies and the job's
+// section of worker_pool.tasks_config.jobs
+type jobFactory func(t *task, cfg config.JobConfig) (*Job, error)
+
+var jobFactories = map[string]jobFactory{}
+
+// defaultEnabledJobs run when they have no section in
+// worker_pool.tasks_config.jobs, the watch job always ran before jobs could
+// be configured
+var defaultEnabledJobs = map[string]bool{
+	watchJobName: true,
+}
+
+// registerJob makes a job known to the task manager. Jobs register themselves
+// from the init function of the file that implements them.
+func registerJob(name string, factory jobFactory) {
+	if _, exists := jobFactories[name]; exists {
+		panic(fmt.Sprintf("tasks: job %s is registered twice", name))
+	}
+	jobFactories[name] = factory
+}
+
+// resolveJobConfig returns the config of a job, a job without a section of its own
+// is enabled when it is enabled by default
+func resolveJobConfig(jobs map[string]config.JobConfig, name string) config.JobConfig {
+	cfg, ok := jobs[name]
+	if !ok {
+		cfg.Enabled = defaultEnabledJobs[name]
+	}
+	return cfg
+}
+
+// registeredJobs returns the names of the registered jobs in a stable order
+func registeredJobs() []string {
+	names := make([]string, 0, len(jobFactories))
+	for name := range jobFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
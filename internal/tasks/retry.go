@@ -0,0 +1,81 @@
+package tasks
+
+import (
+	"context"
+	"mashaghel/internal/config"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 200   // In milliseconds
+	defaultRetryMaxBackoff     = 10000 // In milliseconds
+	defaultRetryMultiplier     = 2
+)
+
+// retryPolicy retries a work item with an exponential, jittered backoff
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+}
+
+func newRetryPolicy(cfg config.RetryConfig) retryPolicy {
+	p := retryPolicy{
+		maxAttempts:    cfg.MaxAttempts,
+		initialBackoff: time.Duration(cfg.InitialBackoff) * time.Millisecond,
+		maxBackoff:     time.Duration(cfg.MaxBackoff) * time.Millisecond,
+		multiplier:     cfg.Multiplier,
+		jitter:         cfg.Jitter,
+	}
+	if p.maxAttempts == 0 {
+		p.maxAttempts = defaultRetryMaxAttempts
+	}
+	if p.initialBackoff == 0 {
+		p.initialBackoff = defaultRetryInitialBackoff * time.Millisecond
+	}
+	if p.maxBackoff == 0 {
+		p.maxBackoff = defaultRetryMaxBackoff * time.Millisecond
+	}
+	if p.multiplier == 0 {
+		p.multiplier = defaultRetryMultiplier
+	}
+	return p
+}
+
+// backoff returns the delay before the given retry, starting at 1
+func (p retryPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.initialBackoff) * math.Pow(p.multiplier, float64(retry-1))
+	backoff = math.Min(backoff, float64(p.maxBackoff))
+	if p.jitter > 0 {
+		// Spread the delay over backoff ± jitter
+		backoff += backoff * p.jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// Do calls fn until it succeeds, the attempts are used up or ctx is done, and
+// returns the last error
+func (p retryPolicy) Do(ctx context.Context, fn func(attempt int) error) error {
+	var err error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(p.backoff(attempt - 1))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+	}
+	return err
+}
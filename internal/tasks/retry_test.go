@@ -0,0 +1,72 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/config"
+	"testing"
+	"time"
+)
+
+func Test_retryPolicy_backoff(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{
+		InitialBackoff: 100,
+		MaxBackoff:     1000,
+		Multiplier:     2,
+		Jitter:         0.5,
+	})
+
+	testCases := []struct {
+		retry int
+		base  time.Duration
+	}{
+		{retry: 1, base: 100 * time.Millisecond},
+		{retry: 2, base: 200 * time.Millisecond},
+		{retry: 4, base: 800 * time.Millisecond},
+		{retry: 10, base: time.Second},
+	}
+
+	for _, tt := range testCases {
+		for range 100 {
+			got := policy.backoff(tt.retry)
+			if got < tt.base/2 || got > tt.base*3/2 {
+				t.Fatalf("backoff(%d) = %v, want within 50%% of %v", tt.retry, got, tt.base)
+			}
+		}
+	}
+}
+
+func Test_retryPolicy_Do(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{MaxAttempts: 3, InitialBackoff: 1})
+	errFailed := errors.New("failed")
+
+	var attempts int
+	err := policy.Do(context.Background(), func(attempt int) error {
+		attempts = attempt
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) || attempts != 3 {
+		t.Errorf("Do() error = %v after %d attempts, want %v after 3", err, attempts, errFailed)
+	}
+
+	attempts = 0
+	err = policy.Do(context.Background(), func(attempt int) error {
+		attempts = attempt
+		if attempt < 2 {
+			return errFailed
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("Do() error = %v after %d attempts, want nil after 2", err, attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = newRetryPolicy(config.RetryConfig{MaxAttempts: 3, InitialBackoff: 60000}).Do(ctx, func(int) error {
+		return errFailed
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,245 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mashaghel/internal/database/scylla"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultScanRanges   = 16
+	defaultScanPageSize = 1000
+)
+
+// ErrInterrupted is returned by runs that stopped early because the task
+// manager is stopping. They resume from their checkpoints.
+var ErrInterrupted = errors.New("run interrupted by shutdown")
+
+// tokenRange is a range of the Murmur3 token ring, exclusive of Start and
+// inclusive of End
+type tokenRange struct {
+	Start int64
+	End   int64
+}
+
+// splitTokenRing splits the whole Murmur3 token ring into n contiguous
+// ranges of about the same width
+func splitTokenRing(n int) []tokenRange {
+	if n < 1 {
+		n = 1
+	}
+
+	step := math.MaxUint64 / uint64(n)
+	ranges := make([]tokenRange, n)
+	start := int64(math.MinInt64)
+	for i := 0; i < n; i++ {
+		end := int64(math.MaxInt64)
+		if i < n-1 {
+			end = int64(uint64(start) + step)
+		}
+		ranges[i] = tokenRange{Start: start, End: end}
+		start = end
+	}
+	return ranges
+}
+
+// ringScanner walks a table partition by partition over concurrently
+// scanned ranges of the token ring. Its query has to select the partition
+// token as first column and restrict it with
+// `token(...) > ? AND token(...) <= ?`. Every range resumes from its own
+// checkpoint, a scanner without checkpoint store always scans the whole ring
+// and persists nothing.
+type ringScanner[R any] struct {
+	name        string
+	scylla      scylla.ScyllaDB
+	logger      *zap.Logger
+	checkpoints *checkpointStore
+	configs     ringScanConfig
+	query       string
+	// scan reads the current row of the scanner and returns its token
+	scan func(scanner gocql.Scanner) (int64, R, error)
+	// handle processes every row of a partition
+	handle func(ctx context.Context, run scanRun, token int64, rows []R) error
+}
+
+// scanRun identifies the run a partition is handled in. A resumed run keeps
+// the id and start time it had before the restart.
+type scanRun struct {
+	ID        string
+	StartedAt time.Time
+}
+
+type ringScanConfig struct {
+	Ranges             int
+	PageSize           int
+	CheckpointInterval time.Duration
+}
+
+// Run scans every unfinished range of the current run on the worker pool
+func (s *ringScanner[R]) Run(ctx context.Context, workers *Workers) error {
+	ranges := splitTokenRing(s.configs.Ranges)
+	checkpoints, resumed, err := s.resume(ctx, ranges)
+	if err != nil {
+		s.logger.Error("Failed to load checkpoints", zap.Error(err))
+		return err
+	}
+	run := scanRun{ID: checkpoints[0].RunID, StartedAt: checkpoints[0].RunStartedAt}
+
+	s.logger.Info("Starting token ring scan",
+		zap.String("runID", run.ID),
+		zap.Bool("resumed", resumed),
+		zap.Int("ranges", len(ranges)),
+		zap.Time("runStartedAt", run.StartedAt),
+	)
+
+	var mu sync.Mutex
+	var errs []error
+	for i := range checkpoints {
+		cp := &checkpoints[i]
+		if cp.Finished {
+			continue
+		}
+		if draining(workers) {
+			mu.Lock()
+			errs = append(errs, ErrInterrupted)
+			mu.Unlock()
+			break
+		}
+		if err := workers.Submit(func() {
+			if err := s.scanRange(ctx, run, cp, workers); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("range %d: %w", cp.RangeID, err))
+				mu.Unlock()
+			}
+		}); err != nil {
+			s.logger.Error("Failed to submit range scan", zap.Error(err), zap.Int("rangeID", cp.RangeID))
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	}
+	workers.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (s *ringScanner[R]) resume(ctx context.Context, ranges []tokenRange) ([]Checkpoint, bool, error) {
+	if s.checkpoints == nil {
+		return newRunCheckpoints(s.name, ranges), false, nil
+	}
+	return s.checkpoints.Resume(ctx, s.name, ranges)
+}
+
+func (s *ringScanner[R]) scanRange(ctx context.Context, run scanRun, cp *Checkpoint, workers *Workers) (err error) {
+	ctx, span := tracer.Start(ctx, "job.range", trace.WithAttributes(
+		attribute.String("job.name", s.name),
+		attribute.String("scan.run_id", run.ID),
+		attribute.Int("scan.range_id", cp.RangeID),
+		attribute.Int64("scan.token_from", cp.Token),
+		attribute.Int64("scan.token_to", cp.RangeEnd),
+		attribute.String("db.consistency", gocql.One.String()),
+	))
+	var partitions, scanned int
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("scan.partitions", partitions),
+			attribute.Int("scan.rows", scanned),
+		)
+		endSpan(span, err)
+	}()
+
+	logger := s.logger.With(zap.Int("rangeID", cp.RangeID))
+	checkpointer := newRangeCheckpointer(s.checkpoints, cp, s.configs.CheckpointInterval, logger)
+
+	logger.Info("Scanning token range",
+		zap.Int64("from", cp.Token),
+		zap.Int64("to", cp.RangeEnd),
+	)
+
+	iter := s.scylla.Session().Query(s.query, cp.Token, cp.RangeEnd).
+		WithContext(ctx).
+		PageSize(s.configs.PageSize).
+		Consistency(gocql.One).
+		Iter()
+	scanner := iter.Scanner()
+
+	var current int64
+	var rows []R
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		partitionCtx, partitionSpan := tracer.Start(ctx, "job.partition", trace.WithAttributes(
+			attribute.String("job.name", s.name),
+			attribute.Int64("scan.token", current),
+			attribute.Int("scan.rows", len(rows)),
+		))
+		err := s.handle(partitionCtx, run, current, rows)
+		endSpan(partitionSpan, err)
+		if err != nil {
+			return err
+		}
+		partitions++
+		scanned += len(rows)
+		checkpointer.Processed(ctx, current)
+		workers.Processed(len(rows))
+		rows = nil
+		return nil
+	}
+
+	for scanner.Next() {
+		token, row, err := s.scan(scanner)
+		if err != nil {
+			iter.Close()
+			checkpointer.Flush(context.Background(), false)
+			return err
+		}
+		if token != current {
+			if err := flush(); err != nil {
+				iter.Close()
+				checkpointer.Flush(context.Background(), false)
+				return err
+			}
+			if draining(workers) {
+				iter.Close()
+				checkpointer.Flush(context.Background(), false)
+				logger.Info("Stopping token range scan to drain", zap.Int64("token", cp.Token))
+				return ErrInterrupted
+			}
+			current = token
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		logger.Error("Error scanning token range", zap.Error(err))
+		checkpointer.Flush(context.Background(), false)
+		return err
+	}
+	if err := flush(); err != nil {
+		checkpointer.Flush(context.Background(), false)
+		return err
+	}
+
+	checkpointer.Flush(ctx, true)
+	logger.Info("Token range scanned")
+	return nil
+}
+
+func draining(workers *Workers) bool {
+	select {
+	case <-workers.Draining():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,46 @@
+package tasks
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_splitTokenRing(t *testing.T) {
+	testCases := []struct {
+		name string
+		n    int
+	}{
+		{name: "single range", n: 1},
+		{name: "even split", n: 16},
+		{name: "odd split", n: 7},
+		{name: "invalid count falls back to one range", n: 0},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges := splitTokenRing(tt.n)
+
+			want := tt.n
+			if want < 1 {
+				want = 1
+			}
+			if len(ranges) != want {
+				t.Fatalf("splitTokenRing() returned %d ranges, want %d", len(ranges), want)
+			}
+			if ranges[0].Start != math.MinInt64 {
+				t.Errorf("first range starts at %d, want %d", ranges[0].Start, int64(math.MinInt64))
+			}
+			if ranges[len(ranges)-1].End != math.MaxInt64 {
+				t.Errorf("last range ends at %d, want %d", ranges[len(ranges)-1].End, int64(math.MaxInt64))
+			}
+			for i := range ranges {
+				if ranges[i].Start >= ranges[i].End {
+					t.Errorf("range %d is empty: %+v", i, ranges[i])
+				}
+				if i > 0 && ranges[i].Start != ranges[i-1].End {
+					t.Errorf("range %d does not continue range %d: %+v %+v", i, i-1, ranges[i-1], ranges[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package tasks
+
+import (
+	"fmt"
+	"mashaghel/internal/config"
+	"math/rand"
+	"time"
+	_ "time/tzdata" // production image has no zoneinfo
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// Schedule tells the scheduler when a job has to run next
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+// cronParser accepts standard five field expressions, an optional leading
+// seconds field and descriptors such as @hourly or @every 5m
+var cronParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// zonedSchedule evaluates a cron schedule in a fixed time zone
+type zonedSchedule struct {
+	schedule cron.Schedule
+	location *time.Location
+}
+
+func (z *zonedSchedule) Next(t time.Time) time.Time {
+	return z.schedule.Next(t.In(z.location))
+}
+
+// newSchedule builds the schedule of a job. Jobs without a cron expression
+// run every interval.
+func newSchedule(cfg config.JobConfig, interval time.Duration) (Schedule, error) {
+	if cfg.Schedule == "" {
+		if interval <= 0 {
+			return nil, fmt.Errorf("job has neither a schedule nor an interval")
+		}
+		return cron.Every(interval), nil
+	}
+
+	location := time.Local
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %s: %w", cfg.Timezone, err)
+		}
+		location = loc
+	}
+
+	schedule, err := cronParser.Parse(cfg.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %s: %w", cfg.Schedule, err)
+	}
+
+	return &zonedSchedule{schedule: schedule, location: location}, nil
+}
+
+// scheduler fires the runs of a job according to its schedule until quit is
+// closed
+type scheduler struct {
+	logger *zap.Logger
+	quit   <-chan struct{}
+	run    func(job *Job)
+}
+
+func (s *scheduler) loop(job *Job) {
+	logger := s.logger.With(zap.String("job", job.Name))
+	logger.Info("Starting job scheduler", zap.Duration("jitter", job.Jitter), zap.Bool("allowOverlap", job.AllowOverlap))
+
+	for {
+		now := time.Now()
+		next := job.Schedule.Next(now)
+		if next.IsZero() {
+			logger.Warn("Schedule has no upcoming activation, stopping job scheduler")
+			return
+		}
+		if job.Jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(job.Jitter))))
+		}
+		logger.Debug("Next run scheduled", zap.Time("at", next))
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+		case <-job.trigger:
+			timer.Stop()
+			logger.Info("Running manually triggered job")
+		case <-s.quit:
+			timer.Stop()
+			logger.Info("Received quit signal, stopping job scheduler")
+			return
+		}
+
+		if !job.start() {
+			logger.Warn("Previous run is still in progress, skipping this run")
+			continue
+		}
+
+		go func() {
+			defer job.running.Add(-1)
+			s.run(job)
+		}()
+	}
+}
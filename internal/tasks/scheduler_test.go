@@ -0,0 +1,118 @@
+package tasks
+
+import (
+	"errors"
+	"mashaghel/internal/config"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func Test_newSchedule(t *testing.T) {
+	tehran, err := time.LoadLocation("Asia/Tehran")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		cfg      config.JobConfig
+		interval time.Duration
+		now      time.Time
+		want     time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "falls back to the interval",
+			cfg:      config.JobConfig{},
+			interval: 30 * time.Second,
+			now:      time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC),
+			want:     time.Date(2025, 1, 1, 10, 0, 30, 0, time.UTC),
+		},
+		{
+			name:     "cron expression in the configured time zone",
+			cfg:      config.JobConfig{Schedule: "*/5 2-5 * * *", Timezone: "Asia/Tehran"},
+			interval: 30 * time.Second,
+			now:      time.Date(2025, 1, 1, 1, 58, 0, 0, tehran),
+			want:     time.Date(2025, 1, 1, 2, 0, 0, 0, tehran),
+		},
+		{
+			name:     "cron expression skips the window end",
+			cfg:      config.JobConfig{Schedule: "*/5 2-5 * * *", Timezone: "Asia/Tehran"},
+			interval: 30 * time.Second,
+			now:      time.Date(2025, 1, 1, 5, 55, 0, 0, tehran),
+			want:     time.Date(2025, 1, 2, 2, 0, 0, 0, tehran),
+		},
+		{
+			name:    "invalid expression",
+			cfg:     config.JobConfig{Schedule: "every day"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid time zone",
+			cfg:     config.JobConfig{Schedule: "@hourly", Timezone: "Mars/Olympus"},
+			wantErr: true,
+		},
+		{
+			name:    "no schedule and no interval",
+			cfg:     config.JobConfig{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := newSchedule(tt.cfg, tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := schedule.Next(tt.now); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_task_Trigger(t *testing.T) {
+	job := &Job{Name: "a", trigger: make(chan struct{}, 1)}
+	tm := &task{logger: zap.NewNop(), jobs: []*Job{job}}
+
+	if err := tm.Trigger("b"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Trigger() unknown job error = %v, want %v", err, ErrJobNotFound)
+	}
+	if err := tm.Trigger("a"); err != nil {
+		t.Errorf("Trigger() error = %v", err)
+	}
+	if err := tm.Trigger("a"); !errors.Is(err, ErrJobQueued) {
+		t.Errorf("Trigger() queued error = %v, want %v", err, ErrJobQueued)
+	}
+
+	<-job.trigger
+	job.running.Add(1)
+	if err := tm.Trigger("a"); !errors.Is(err, ErrJobRunning) {
+		t.Errorf("Trigger() running error = %v, want %v", err, ErrJobRunning)
+	}
+}
+
+func Test_Job_start(t *testing.T) {
+	job := &Job{Name: "a"}
+	if !job.start() {
+		t.Fatal("start() = false, want true")
+	}
+	if job.start() {
+		t.Error("start() of a running job = true, want false")
+	}
+
+	job.AllowOverlap = true
+	if !job.start() {
+		t.Error("start() of an overlapping job = false, want true")
+	}
+	if got := job.running.Load(); got != 2 {
+		t.Errorf("running = %d, want 2", got)
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"time"
 
 	"github.com/gocql/gocql"
-	"github.com/panjf2000/ants/v2"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"go.uber.org/zap"
@@ -96,47 +95,9 @@ func SetupTables() error {
 
 func createTables(ctx context.Context) error {
 	// scyllaDB tables
-
-	// ########################################################
-	// ########################################################
-	// ###################      Watch      ####################
-	// ########################################################
-	// ########################################################
-
-	// Create watched table
-	err := scyllaDB.Session().Query(`CREATE TABLE IF NOT EXISTS watched (
-		profile_id UUID,
-		play_id UUID,
-		duration INT,  -- Duration in seconds 
-		watched_at TIMEUUID, 
-		PRIMARY KEY (profile_id, play_id)
-	);`).WithContext(ctx).Exec()
-	if err != nil {
-		return fmt.Errorf("failed to create watched table: %w", err)
-	}
-
-	// Create ordered_watch table
-	err = scyllaDB.Session().Query(`CREATE TABLE IF NOT EXISTS ordered_watch (
-		profile_id UUID,
-		play_id UUID,
-		duration INT,
-		watched_at TIMEUUID,
-		PRIMARY KEY (profile_id, watched_at)
-	) WITH CLUSTERING ORDER BY (watched_at DESC);`).WithContext(ctx).Exec()
-	if err != nil {
-		return fmt.Errorf("failed to create ordered_watch table: %w", err)
-	}
-
-	// Create recent_watch table
-	err = scyllaDB.Session().Query(`CREATE TABLE IF NOT EXISTS recent_watch (
-		profile_id UUID,
-		play_id UUID,
-		duration INT,  -- Duration in seconds
-		watched_at TIMEUUID, 
-		PRIMARY KEY (profile_id, play_id)
-	)`).WithContext(ctx).Exec()
-	if err != nil {
-		return fmt.Errorf("failed to create recent_watch table: %w", err)
+	migration := scylla.NewMigration(scyllaDB, zap.NewExample())
+	if err := migration.Apply(ctx, "../database/scylla/migrations", ""); err != nil {
+		return fmt.Errorf("failed to apply scylla migrations: %w", err)
 	}
 
 	// arangoDB collections
@@ -192,23 +153,32 @@ func generateData() error {
 	return nil
 }
 
-func createTaskInstance() *task {
-	taskInstance := &task{
-		scylla: scyllaDB,
-		logger: zap.NewExample(),
-		workerpool: func() *ants.Pool {
-			pool, err := ants.NewPool(3)
-			if err != nil {
-				log.Fatalf("failed to create worker pool: %v", err)
-			}
-			return pool
-		}(),
-		configs: &config.WorkerPoolConfig{
+func createTaskInstance() Task {
+	taskInstance, err := NewTaskManager(
+		scyllaDB,
+		nil,
+		nil,
+		nil,
+		zap.NewExample(),
+		&config.WorkerPoolConfig{
+			WorkerPoolSize: 3,
 			TasksConfig: config.TasksConfig{
 				WatchCooldownDuration: 3,
 				WatchAgeLimit:         24,
+				CheckpointInterval:    1,
+				ScanRanges:            4,
+				ScanPageSize:          10,
+				Jobs: map[string]config.JobConfig{
+					watchJobName: {Enabled: true},
+				},
 			},
 		},
+	)
+	if err != nil {
+		log.Fatalf("failed to create task manager: %v", err)
+	}
+	if err := taskInstance.InitWorkerPool(); err != nil {
+		log.Fatalf("failed to create worker pool: %v", err)
 	}
 
 	return taskInstance
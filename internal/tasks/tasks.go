@@ -1,40 +1,170 @@
 package tasks
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"mashaghel/internal/config"
+	"mashaghel/internal/database/arango"
 	"mashaghel/internal/database/scylla"
+	natsHelper "mashaghel/internal/helper/nats"
+	"mashaghel/internal/producers"
+	"sync"
 	"time"
 
 	"github.com/panjf2000/ants/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 type Task interface {
 	Start()
-	Stop()
+	// Stop stops scheduling runs and waits for the running ones to finish
+	// their current work, until the drain timeout or ctx is done. Runs that
+	// are still going then are cancelled.
+	Stop(ctx context.Context) error
 	InitWorkerPool() error
+	// Checkpoints returns the position and run start time of the latest run
+	// of a job that scans the token ring, one checkpoint per token range
+	Checkpoints(ctx context.Context, jobName string) ([]Checkpoint, error)
+	// Trigger enqueues an immediate run of a job on the started task manager
+	Trigger(jobName string) error
+	// RunOnce runs a job a single time and blocks until the run is done
+	RunOnce(ctx context.Context, jobName string) error
+	// DryRun runs a job a single time without writing anything and returns
+	// the report of what it would have written
+	DryRun(ctx context.Context, jobName string) (any, error)
+	// DeadLetters returns the latest work items of a job that failed every
+	// attempt of its retry policy
+	DeadLetters(ctx context.Context, jobName string, limit int) ([]DeadLetter, error)
+	// Replay runs a dead letter of a job again and removes it once it
+	// succeeded
+	Replay(ctx context.Context, jobName string, id string) error
 }
 
+var (
+	ErrJobNotFound        = errors.New("job is not registered or not enabled")
+	ErrJobRunning         = errors.New("job is already running")
+	ErrJobQueued          = errors.New("a run of the job is already queued")
+	ErrLeaderLockHeld     = errors.New("another replica is the leader of the job")
+	ErrDrainTimeout       = errors.New("job runs did not finish before the drain timeout")
+	ErrStopped            = errors.New("task manager is stopped")
+	ErrNoReplay           = errors.New("job does not support replaying dead letters")
+	ErrNoDryRun           = errors.New("job does not support dry runs")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+const defaultDrainTimeout = 30 // In seconds
+
 type task struct {
 	scylla     scylla.ScyllaDB
+	arango     arango.ArangoDB
+	redis      producers.RedisClient
+	nats       natsHelper.NatsConnection
 	logger     *zap.Logger
 	workerpool *ants.Pool
-	quit       chan struct{}
-	configs    *config.WorkerPoolConfig
+	// quit stops the schedulers and tells the running jobs to drain
+	quit chan struct{}
+	// ctx is the context of scheduled runs, it is cancelled once draining
+	// them took too long
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	stopped     bool
+	stopOnce    sync.Once
+	stopErr     error
+	runs        sync.WaitGroup
+	configs     *config.WorkerPoolConfig
+	jobs        []*Job
+	leader      *leaderElector
+	checkpoints *checkpointStore
+	history     *runHistory
+	deadLetters *deadLetterStore
+	metrics     *jobMetrics
 }
 
 func NewTaskManager(
 	scyllaDB scylla.ScyllaDB,
+	arangoDB arango.ArangoDB,
+	redis producers.RedisClient,
+	natsConn natsHelper.NatsConnection,
 	logger *zap.Logger,
 	configs *config.WorkerPoolConfig,
-) Task {
-	return &task{
+) (Task, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &task{
+		ctx:        ctx,
+		cancel:     cancel,
 		scylla:     scyllaDB,
-		logger:     logger.With(zap.String("task", "watched")),
+		arango:     arangoDB,
+		redis:      redis,
+		nats:       natsConn,
+		logger:     logger,
 		workerpool: nil,
 		configs:    configs,
 		quit:       make(chan struct{}),
+		checkpoints: &checkpointStore{
+			scylla: scyllaDB,
+		},
+		history: newRunHistory(scyllaDB, configs.TasksConfig.RunHistoryTTL),
+		deadLetters: &deadLetterStore{
+			scylla: scyllaDB,
+		},
+	}
+
+	metrics, err := newJobMetrics(func() *ants.Pool { return t.workerpool })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job metrics: %w", err)
+	}
+	t.metrics = metrics
+
+	if configs.TasksConfig.LeaderLock.Enabled {
+		leader, err := newLeaderElector(redis, configs.TasksConfig.LeaderLock, logger)
+		if err != nil {
+			return nil, err
+		}
+		t.leader = leader
+	}
+
+	for name := range configs.TasksConfig.Jobs {
+		if _, ok := jobFactories[name]; !ok {
+			logger.Warn("Configured job is not registered", zap.String("job", name))
+		}
 	}
+
+	for _, name := range registeredJobs() {
+		jobConfig := resolveJobConfig(configs.TasksConfig.Jobs, name)
+		if _, ok := configs.TasksConfig.Jobs[name]; !ok && jobConfig.Enabled {
+			logger.Info("Job is not configured, enabling it by default", zap.String("job", name))
+		}
+		if !jobConfig.Enabled {
+			logger.Info("Job is disabled", zap.String("job", name))
+			continue
+		}
+
+		job, err := jobFactories[name](t, jobConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create job %s: %w", name, err)
+		}
+		if jobConfig.Interval > 0 {
+			job.Interval = time.Duration(jobConfig.Interval) * time.Second
+		}
+		if jobConfig.Concurrency > 0 {
+			job.Concurrency = jobConfig.Concurrency
+		}
+		schedule, err := newSchedule(jobConfig, job.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to schedule job %s: %w", name, err)
+		}
+		job.Schedule = schedule
+		job.Jitter = time.Duration(jobConfig.Jitter) * time.Second
+		job.AllowOverlap = jobConfig.AllowOverlap
+		job.trigger = make(chan struct{}, 1)
+		t.jobs = append(t.jobs, job)
+	}
+
+	return t, nil
 }
 
 func (t *task) InitWorkerPool() error {
@@ -50,40 +180,241 @@ func (t *task) InitWorkerPool() error {
 }
 
 func (t *task) Start() {
-	t.logger.Info("Starting task manager")
-	go t.watchBackgroundJob()
+	t.logger.Info("Starting task manager", zap.Int("jobs", len(t.jobs)))
+	s := &scheduler{
+		logger: t.logger,
+		quit:   t.quit,
+		run: func(job *Job) {
+			_ = t.runJob(t.ctx, job)
+		},
+	}
+	for _, job := range t.jobs {
+		go s.loop(job)
+	}
 }
 
-func (t *task) watchBackgroundJob() {
-	t.logger.Info("Starting background job watcher")
+func (t *task) runJob(ctx context.Context, job *Job) (err error) {
+	if !t.beginRun() {
+		return ErrStopped
+	}
+	defer t.runs.Done()
 
-	ticker := time.NewTicker(time.Duration(t.configs.TasksConfig.WatchCooldownDuration) * time.Second)
-	defer ticker.Stop()
+	logger := t.logger.With(zap.String("job", job.Name))
 
-	longPolling := func() {
-		defer func() {
-			if r := recover(); r != nil {
-				t.logger.Error("Panic in run", zap.Any("panic", r))
-			}
-		}()
+	if job.Exclusive && t.leader != nil {
+		lease, err := t.leader.acquire(ctx, job.Name)
+		if err != nil {
+			logger.Error("Failed to acquire leader lock, skipping run", zap.Error(err))
+			return err
+		}
+		if lease == nil {
+			logger.Info("Another replica is the leader of this job, skipping run")
+			return ErrLeaderLockHeld
+		}
+		defer t.leader.release(lease)
+		ctx = lease.ctx
+	}
+
+	ctx, span := tracer.Start(ctx, "job.run", trace.WithAttributes(
+		attribute.String("job.name", job.Name),
+		attribute.Int("job.concurrency", job.Concurrency),
+	))
 
-		select {
-		case <-ticker.C:
-			t.processWatched()
-		case <-t.quit:
-			t.logger.Info("Received quit signal, stopping background job watcher")
+	run, err := t.history.Start(ctx, job.Name)
+	if err != nil {
+		logger.Error("Failed to record job run", zap.Error(err))
+	} else {
+		span.SetAttributes(attribute.String("job.run_id", run.RunID))
+	}
+
+	workers := newWorkers(t.workerpool, job.Concurrency, t.quit)
+	started := time.Now()
+	var runErr error
+	defer func() {
+		if r := recover(); r != nil {
+			runErr = fmt.Errorf("panic: %v", r)
+			logger.Error("Panic in run", zap.Any("panic", r))
+		}
+		err = runErr
+		t.metrics.RunFinished(job.Name, started, runStatus(runErr))
+		span.SetAttributes(
+			attribute.String("job.status", runStatus(runErr)),
+			attribute.Int64("job.rows_processed", workers.processed.Load()),
+		)
+		endSpan(span, runErr)
+		if run == nil {
 			return
 		}
+		if err := t.history.Finish(context.Background(), run, workers.processed.Load(), runErr); err != nil {
+			logger.Error("Failed to record job run result", zap.Error(err), zap.String("runID", run.RunID))
+		}
+	}()
+
+	runErr = job.Run(ctx, workers)
+	if runErr != nil {
+		logger.Error("Job run failed", zap.Error(runErr))
+	}
+	workers.Wait()
+	return runErr
+}
+
+// beginRun counts a starting run unless the task manager is stopping
+func (t *task) beginRun() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.runs.Add(1)
+	return true
+}
+
+func (t *task) job(jobName string) (*Job, error) {
+	for _, job := range t.jobs {
+		if job.Name == jobName {
+			return job, nil
+		}
+	}
+	return nil, ErrJobNotFound
+}
+
+func (t *task) Trigger(jobName string) error {
+	job, err := t.job(jobName)
+	if err != nil {
+		return err
+	}
+	if job.running.Load() > 0 && !job.AllowOverlap {
+		return ErrJobRunning
+	}
+
+	select {
+	case job.trigger <- struct{}{}:
+		t.logger.Info("Job run triggered", zap.String("job", jobName))
+		return nil
+	default:
+		return ErrJobQueued
+	}
+}
+
+func (t *task) RunOnce(ctx context.Context, jobName string) error {
+	job, err := t.job(jobName)
+	if err != nil {
+		return err
 	}
 
-	for {
-		longPolling()
+	job.running.Add(1)
+	defer job.running.Add(-1)
+	return t.runJob(ctx, job)
+}
+
+func (t *task) DryRun(ctx context.Context, jobName string) (any, error) {
+	job, err := t.job(jobName)
+	if err != nil {
+		return nil, err
 	}
+	if job.DryRun == nil {
+		return nil, ErrNoDryRun
+	}
+
+	workers := newWorkers(t.workerpool, job.Concurrency, nil)
+	return job.DryRun(ctx, workers)
+}
+
+func (t *task) DeadLetters(ctx context.Context, jobName string, limit int) ([]DeadLetter, error) {
+	return t.deadLetters.List(ctx, jobName, limit)
 }
 
-func (t *task) Stop() {
+func (t *task) Replay(ctx context.Context, jobName string, id string) error {
+	job, err := t.job(jobName)
+	if err != nil {
+		return err
+	}
+	if job.Replay == nil {
+		return ErrNoReplay
+	}
+
+	dl, err := t.deadLetters.Get(ctx, jobName, id)
+	if err != nil {
+		return err
+	}
+	if err := job.Replay(ctx, dl.Payload); err != nil {
+		t.logger.Error("Failed to replay dead letter", zap.Error(err), zap.String("job", jobName), zap.String("id", id))
+		return err
+	}
+	t.logger.Info("Dead letter replayed", zap.String("job", jobName), zap.String("id", id), zap.String("itemKey", dl.ItemKey))
+	return t.deadLetters.Delete(ctx, jobName, id)
+}
+
+func (t *task) Checkpoints(ctx context.Context, jobName string) ([]Checkpoint, error) {
+	return t.checkpoints.Load(ctx, jobName)
+}
+
+// ringScanConfig returns the settings shared by the jobs that scan the token
+// ring
+func (t *task) ringScanConfig() ringScanConfig {
+	cfg := ringScanConfig{
+		Ranges:             t.configs.TasksConfig.ScanRanges,
+		PageSize:           t.configs.TasksConfig.ScanPageSize,
+		CheckpointInterval: time.Duration(t.configs.TasksConfig.CheckpointInterval) * time.Second,
+	}
+	if cfg.Ranges == 0 {
+		cfg.Ranges = defaultScanRanges
+	}
+	if cfg.PageSize == 0 {
+		cfg.PageSize = defaultScanPageSize
+	}
+	return cfg
+}
+
+// Stop is safe to call more than once, e.g. by the fx OnStop hook and a
+// signal handler, the later calls return the result of the first one
+func (t *task) Stop(ctx context.Context) error {
+	t.stopOnce.Do(func() {
+		t.stopErr = t.stop(ctx)
+	})
+	return t.stopErr
+}
+
+func (t *task) stop(ctx context.Context) error {
 	t.logger.Info("Stopping task manager")
-	t.quit <- struct{}{}
-	t.workerpool.Release()
-	t.logger.Info("Task manager stopped successfully")
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+	close(t.quit)
+
+	drainTimeout := time.Duration(t.configs.DrainTimeout) * time.Second
+	if drainTimeout == 0 {
+		drainTimeout = defaultDrainTimeout * time.Second
+	}
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		t.runs.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		t.logger.Info("Job runs drained")
+	case <-drainCtx.Done():
+		t.logger.Warn("Job runs did not drain in time, cancelling them", zap.Duration("drainTimeout", drainTimeout))
+		err = ErrDrainTimeout
+	}
+	t.cancel()
+
+	if t.workerpool != nil {
+		if deadline, ok := ctx.Deadline(); ok {
+			if releaseErr := t.workerpool.ReleaseTimeout(time.Until(deadline)); releaseErr != nil {
+				t.logger.Warn("Worker pool did not release in time", zap.Error(releaseErr))
+			}
+		} else {
+			t.workerpool.Release()
+		}
+	}
+
+	t.logger.Info("Task manager stopped", zap.Bool("drained", err == nil))
+	return err
 }
@@ -0,0 +1,81 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/config"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func Test_task_Stop(t *testing.T) {
+	testCases := []struct {
+		name    string
+		runTime time.Duration
+		wantErr error
+	}{
+		{name: "waits for running jobs", runTime: 100 * time.Millisecond},
+		{name: "cancels jobs after the drain timeout", runTime: time.Hour, wantErr: ErrDrainTimeout},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			tm := &task{
+				logger:  zap.NewNop(),
+				quit:    make(chan struct{}),
+				ctx:     ctx,
+				cancel:  cancel,
+				configs: &config.WorkerPoolConfig{DrainTimeout: 1},
+			}
+
+			if !tm.beginRun() {
+				t.Fatal("beginRun() = false before stop")
+			}
+			go func() {
+				defer tm.runs.Done()
+				select {
+				case <-time.After(tt.runTime):
+				case <-tm.ctx.Done():
+				}
+			}()
+
+			if err := tm.Stop(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Stop() error = %v, want %v", err, tt.wantErr)
+			}
+			if tm.ctx.Err() == nil {
+				t.Error("run context is not cancelled after stop")
+			}
+			if tm.beginRun() {
+				t.Error("beginRun() = true after stop")
+			}
+			if err := tm.Stop(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Errorf("second Stop() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_resolveJobConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		jobs        map[string]config.JobConfig
+		job         string
+		wantEnabled bool
+	}{
+		{name: "watch runs without a jobs section", jobs: nil, job: watchJobName, wantEnabled: true},
+		{name: "watch runs without its own section", jobs: map[string]config.JobConfig{"trending": {Enabled: true}}, job: watchJobName, wantEnabled: true},
+		{name: "watch can be disabled", jobs: map[string]config.JobConfig{watchJobName: {Enabled: false}}, job: watchJobName, wantEnabled: false},
+		{name: "other jobs are disabled by default", jobs: nil, job: "trending", wantEnabled: false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveJobConfig(tt.jobs, tt.job); got.Enabled != tt.wantEnabled {
+				t.Errorf("resolveJobConfig() enabled = %v, want %v", got.Enabled, tt.wantEnabled)
+			}
+		})
+	}
+}
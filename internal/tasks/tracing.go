@@ -0,0 +1,22 @@
+package tasks
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "mashaghel/internal/tasks"
+
+// tracer creates the spans of job runs, token ranges, partitions and
+// batches on the global tracer provider
+var tracer = otel.Tracer(tracerName)
+
+// endSpan ends a span and marks it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
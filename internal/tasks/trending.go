@@ -0,0 +1,406 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/config"
+	"mashaghel/internal/consumers"
+	"mashaghel/internal/database/arango"
+	"mashaghel/internal/database/scylla"
+	"mashaghel/internal/producers"
+	"mashaghel/internal/repositories/models"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arangodb/go-driver/v2/arangodb"
+	"github.com/gocql/gocql"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const trendingJobName = "trending"
+
+const (
+	// defaultTrendingInterval is used when the job has no interval or
+	// schedule of its own
+	defaultTrendingInterval   = 10 * time.Minute
+	defaultTrendingWindow     = 48 // In hours
+	defaultTrendingHalfLife   = 12 // In hours
+	defaultTrendingViewWeight = 1
+	defaultTrendingLikeWeight = 3
+	defaultTrendingTopN       = 50
+)
+
+func init() {
+	registerJob(trendingJobName, newTrendingJob)
+}
+
+const (
+	querySelectTrendingViews = `SELECT id, profile_id, play_id FROM trending_view_log WHERE shard = ? AND bucket = ? AND id > ? AND id < ? LIMIT ?;`
+	queryInsertTrendingView  = `INSERT INTO trending_view_log (shard, bucket, id, profile_id, play_id) VALUES (?, ?, ?, ?, ?);`
+	querySelectLikeLog       = `SELECT id, profile_id, play_id FROM like_log WHERE shard = ? AND bucket = ? AND id > ? AND id < ? LIMIT ?;`
+
+	queryVideoMetadata = `FOR v IN @@collection FILTER v._key IN @keys AND v.publishable RETURN { key: v._key, categories: v.categories, type: v.type }`
+)
+
+// trendingViewSlack is how long before the start of the last finished run the
+// watch job still logs the views it finds, for the plays written to
+// recent_watch while that run passed their partition
+const trendingViewSlack = 5 * time.Minute
+
+// trendingKeys is the set of the trending keys written by the last run, the
+// keys a run does not write again are deleted
+const trendingKeys = models.TrendingKeyPrefix + ":keys"
+
+// trendingEvent is a view or like of a play by a profile
+type trendingEvent struct {
+	playID    gocql.UUID
+	profileID gocql.UUID
+	at        gocql.UUID
+}
+
+// trendingJob ranks the videos by their recent views and likes and writes
+// the top of every category and type into Redis sorted sets
+type trendingJob struct {
+	scylla     scylla.ScyllaDB
+	arango     arango.ArangoDB
+	redis      producers.RedisClient
+	logger     *zap.Logger
+	pageSize   int
+	window     time.Duration
+	halfLife   time.Duration
+	viewWeight float64
+	likeWeight float64
+	topN       int
+}
+
+func newTrendingJob(t *task, _ config.JobConfig) (*Job, error) {
+	if t.arango == nil || t.redis == nil {
+		return nil, errors.New("trending needs arango and redis")
+	}
+
+	j := newTrending(t.configs.TasksConfig)
+	if j.viewWeight != 0 && !t.configs.TasksConfig.Jobs[watchJobName].Enabled {
+		return nil, errors.New("trending needs the watch job to log the views, or a zero view_weight")
+	}
+	j.scylla = t.scylla
+	j.pageSize = t.ringScanConfig().PageSize
+	j.arango = t.arango
+	j.redis = t.redis
+	j.logger = t.logger.With(zap.String("task", trendingJobName))
+
+	return &Job{
+		Name:      trendingJobName,
+		Interval:  defaultTrendingInterval,
+		Exclusive: true,
+		Run:       j.Run,
+	}, nil
+}
+
+// newTrending returns a trending job scoring by the given configs, the
+// defaults fill the values left out
+func newTrending(configs config.TasksConfig) *trendingJob {
+	cfg := configs.Trending
+	j := &trendingJob{
+		window:     time.Duration(cfg.Window) * time.Hour,
+		halfLife:   time.Duration(cfg.HalfLife) * time.Hour,
+		viewWeight: cfg.ViewWeight,
+		likeWeight: cfg.LikeWeight,
+		topN:       cfg.TopN,
+	}
+	if j.window == 0 {
+		j.window = defaultTrendingWindow * time.Hour
+	}
+	if j.halfLife == 0 {
+		j.halfLife = defaultTrendingHalfLife * time.Hour
+	}
+	if j.viewWeight == 0 && j.likeWeight == 0 {
+		j.viewWeight = defaultTrendingViewWeight
+		j.likeWeight = defaultTrendingLikeWeight
+	}
+	if j.topN == 0 {
+		j.topN = defaultTrendingTopN
+	}
+	return j
+}
+
+func scanTrendingEvent(scanner gocql.Scanner) (gocql.UUID, trendingEvent, error) {
+	var event trendingEvent
+	err := scanner.Scan(&event.at, &event.profileID, &event.playID)
+	return event.at, event, err
+}
+
+// Run scores the events of the window and replaces the trending sets. The
+// scores only live for the run, so its scans keep no checkpoints and read
+// the buckets of the whole window.
+func (j *trendingJob) Run(ctx context.Context, workers *Workers) error {
+	now := time.Now()
+	scores := newTrendingScores(now, j.window, j.halfLife)
+
+	sources := []struct {
+		name       string
+		query      string
+		shards     int
+		bucketSize time.Duration
+		weight     float64
+	}{
+		{name: "views", query: querySelectTrendingViews, shards: viewLogShards, bucketSize: viewLogBucketSize, weight: j.viewWeight},
+		{name: "likes", query: querySelectLikeLog, shards: consumers.LikeLogShards, bucketSize: consumers.LikeLogBucketSize, weight: j.likeWeight},
+	}
+	for _, source := range sources {
+		if source.weight == 0 {
+			continue
+		}
+		scanner := &bucketScanner[trendingEvent]{
+			scylla:     j.scylla,
+			logger:     j.logger.With(zap.String("source", source.name)),
+			query:      source.query,
+			shards:     source.shards,
+			bucketSize: source.bucketSize,
+			retention:  j.window,
+			pageSize:   j.pageSize,
+			scan:       scanTrendingEvent,
+			handle: func(_ context.Context, _ int, events []trendingEvent) (int, error) {
+				scores.add(source.name, events, source.weight)
+				return len(events), nil
+			},
+		}
+		if err := scanner.Run(ctx, workers); err != nil {
+			return err
+		}
+	}
+
+	videos, err := j.videoMetadata(ctx, scores.plays())
+	if err != nil {
+		j.logger.Error("Failed to read video metadata", zap.Error(err))
+		return err
+	}
+	rows := rankTrending(scores.scores, videos, j.topN)
+
+	trace.SpanFromContext(ctx).AddEvent("trending.ranked", trace.WithAttributes(
+		attribute.Int("trending.plays", len(scores.scores)),
+		attribute.Int("trending.rows", len(rows)),
+	))
+	j.logger.Info("Trending ranked", zap.Int("plays", len(scores.scores)), zap.Int("rows", len(rows)))
+	return j.write(ctx, rows)
+}
+
+// trendingEventKey identifies an event of a source, a view by the watch it
+// was logged from and a like by the change that liked the video
+type trendingEventKey struct {
+	source    string
+	playID    gocql.UUID
+	profileID gocql.UUID
+	at        int64
+}
+
+// trendingScores sums the decayed weights of the events of every play within
+// the window. An event loses half of its weight every halfLife, and an event
+// that is logged more than once is only scored once.
+type trendingScores struct {
+	now      time.Time
+	window   time.Duration
+	halfLife time.Duration
+
+	mu     sync.Mutex
+	seen   map[trendingEventKey]struct{}
+	scores map[gocql.UUID]float64
+}
+
+func newTrendingScores(now time.Time, window, halfLife time.Duration) *trendingScores {
+	return &trendingScores{
+		now:      now,
+		window:   window,
+		halfLife: halfLife,
+		seen:     make(map[trendingEventKey]struct{}),
+		scores:   make(map[gocql.UUID]float64),
+	}
+}
+
+func (s *trendingScores) add(source string, events []trendingEvent, weight float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range events {
+		age := s.now.Sub(event.at.Time())
+		if age > s.window {
+			continue
+		}
+		key := trendingEventKey{source: source, playID: event.playID, profileID: event.profileID, at: event.at.Time().UnixMilli()}
+		if _, ok := s.seen[key]; ok {
+			continue
+		}
+		s.seen[key] = struct{}{}
+		s.scores[event.playID] += decayedWeight(weight, age, s.halfLife)
+	}
+}
+
+func (s *trendingScores) plays() []string {
+	keys := make([]string, 0, len(s.scores))
+	for playID := range s.scores {
+		keys = append(keys, playID.String())
+	}
+	return keys
+}
+
+// trendingViewLog logs the plays of recent_watch that count as a view in
+// trending_view_log, by when they were watched, so trending reads the views
+// of its window without scanning recent_watch again. The watch job scans
+// recent_watch anyway and logs the plays watched since the start of its last
+// run that finished, or within the window after a restart.
+type trendingViewLog struct {
+	scylla      scylla.ScyllaDB
+	minDuration int
+	window      time.Duration
+
+	mu       sync.Mutex
+	finished time.Time
+}
+
+// since returns the watched_at from which a run logs the plays it scans
+func (l *trendingViewLog) since(runStartedAt time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.finished.IsZero() {
+		return runStartedAt.Add(-l.window)
+	}
+	return l.finished.Add(-trendingViewSlack)
+}
+
+// Finished records the start of a run that scanned every partition
+func (l *trendingViewLog) Finished(startedAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.finished = startedAt
+}
+
+// views returns the plays among rows that count as a view and were watched
+// since the last run
+func (l *trendingViewLog) views(runStartedAt time.Time, rows []playInfo) []playInfo {
+	since := l.since(runStartedAt)
+	var views []playInfo
+	for _, info := range rows {
+		if info.duration >= l.minDuration && !info.watchedAt.Time().Before(since) {
+			views = append(views, info)
+		}
+	}
+	return views
+}
+
+// Record logs the views among rows. A play is keyed by its watched_at and
+// profile, so logging it again writes the same row.
+func (l *trendingViewLog) Record(ctx context.Context, runStartedAt time.Time, rows []playInfo) error {
+	for _, info := range l.views(runStartedAt, rows) {
+		watchedAt := info.watchedAt.Time().UTC()
+		err := l.scylla.Session().Query(queryInsertTrendingView, viewLogShard(info.play_id), watchedAt.Truncate(viewLogBucketSize), info.watchedAt, info.profile_id, info.play_id).
+			WithContext(ctx).
+			Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decayedWeight(weight float64, age, halfLife time.Duration) float64 {
+	if age < 0 {
+		age = 0
+	}
+	return weight * math.Exp2(-float64(age)/float64(halfLife))
+}
+
+// trendingVideo is the metadata a video is ranked by
+type trendingVideo struct {
+	Key        string   `json:"key"`
+	Categories []string `json:"categories"`
+	Type       string   `json:"type"`
+}
+
+// videoMetadata reads the categories and type of the publishable videos
+// among keys
+func (j *trendingJob) videoMetadata(ctx context.Context, keys []string) (map[string]trendingVideo, error) {
+	videos := make(map[string]trendingVideo, len(keys))
+	for start := 0; start < len(keys); start += arangoKeysChunk {
+		cursor, err := j.arango.Database(ctx).Query(ctx, queryVideoMetadata, &arangodb.QueryOptions{
+			BindVars: map[string]interface{}{
+				"@collection": models.VideosCollection,
+				"keys":        keys[start:min(start+arangoKeysChunk, len(keys))],
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for cursor.HasMore() {
+			var video trendingVideo
+			if _, err := cursor.ReadDocument(ctx, &video); err != nil {
+				cursor.Close()
+				return nil, err
+			}
+			videos[video.Key] = video
+		}
+		cursor.Close()
+	}
+	return videos, nil
+}
+
+// rankTrending returns the topN videos of every category and type by score,
+// keyed by their trending key. Plays without a publishable video are left
+// out.
+func rankTrending(scores map[gocql.UUID]float64, videos map[string]trendingVideo, topN int) map[string][]redis.Z {
+	rows := make(map[string][]redis.Z)
+	for playID, score := range scores {
+		video, ok := videos[playID.String()]
+		if !ok {
+			continue
+		}
+		member := redis.Z{Member: video.Key, Score: score}
+		for _, category := range video.Categories {
+			key := models.TrendingKey(models.TrendingByCategory, category)
+			rows[key] = append(rows[key], member)
+		}
+		if video.Type != "" {
+			key := models.TrendingKey(models.TrendingByType, video.Type)
+			rows[key] = append(rows[key], member)
+		}
+	}
+
+	for key, members := range rows {
+		sort.Slice(members, func(i, k int) bool {
+			return members[i].Score > members[k].Score
+		})
+		if len(members) > topN {
+			rows[key] = members[:topN]
+		}
+	}
+	return rows
+}
+
+// write replaces the trending sets in a single transaction and deletes the
+// sets of the last run that have no videos anymore
+func (j *trendingJob) write(ctx context.Context, rows map[string][]redis.Z) error {
+	client := j.redis.RedisStorage().Conn()
+
+	previous, err := client.SMembers(ctx, trendingKeys).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range previous {
+			if _, ok := rows[key]; !ok {
+				pipe.Del(ctx, key)
+			}
+		}
+		pipe.Del(ctx, trendingKeys)
+		for key, members := range rows {
+			pipe.Del(ctx, key)
+			pipe.ZAdd(ctx, key, members...)
+			pipe.SAdd(ctx, trendingKeys, key)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,136 @@
+package tasks
+
+import (
+	"mashaghel/internal/config"
+	"mashaghel/internal/repositories/models"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func Test_decayedWeight(t *testing.T) {
+	testCases := []struct {
+		age  time.Duration
+		want float64
+	}{
+		{age: 0, want: 2},
+		{age: -time.Hour, want: 2},
+		{age: 12 * time.Hour, want: 1},
+		{age: 24 * time.Hour, want: 0.5},
+	}
+
+	for _, tt := range testCases {
+		if got := decayedWeight(2, tt.age, 12*time.Hour); math.Abs(got-tt.want) > 1e-9 {
+			t.Fatalf("decayedWeight(2, %v) = %v, want %v", tt.age, got, tt.want)
+		}
+	}
+}
+
+func Test_rankTrending(t *testing.T) {
+	hot, warm, cold, hidden := gocql.TimeUUID(), gocql.TimeUUID(), gocql.TimeUUID(), gocql.TimeUUID()
+	scores := map[gocql.UUID]float64{hot: 10, warm: 5, cold: 1, hidden: 100}
+	videos := map[string]trendingVideo{
+		hot.String():  {Key: hot.String(), Categories: []string{"drama", "action"}, Type: "movie"},
+		warm.String(): {Key: warm.String(), Categories: []string{"drama"}, Type: "series"},
+		cold.String(): {Key: cold.String(), Categories: []string{"drama"}, Type: "movie"},
+		// hidden is not publishable, so it has no metadata
+	}
+
+	rows := rankTrending(scores, videos, 2)
+
+	want := map[string][]string{
+		models.TrendingKey(models.TrendingByCategory, "drama"):  {hot.String(), warm.String()},
+		models.TrendingKey(models.TrendingByCategory, "action"): {hot.String()},
+		models.TrendingKey(models.TrendingByType, "movie"):      {hot.String(), cold.String()},
+		models.TrendingKey(models.TrendingByType, "series"):     {warm.String()},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rankTrending() returned %d rows, want %d", len(rows), len(want))
+	}
+	for key, members := range want {
+		if len(rows[key]) != len(members) {
+			t.Fatalf("row %s has %d videos, want %d", key, len(rows[key]), len(members))
+		}
+		for i, member := range members {
+			if rows[key][i].Member != member {
+				t.Fatalf("row %s[%d] = %v, want %v", key, i, rows[key][i].Member, member)
+			}
+		}
+	}
+}
+
+func Test_trendingViewLog_views(t *testing.T) {
+	configs, err := config.LoadConfig("../../config/config.yml")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	tasksConfig := configs.WorkerPool.TasksConfig
+	j := newTrending(tasksConfig)
+	l := &trendingViewLog{minDuration: tasksConfig.ViewCounts.MinDuration, window: j.window}
+
+	// The views of the window are logged while the plays are still in
+	// recent_watch, a play is only moved past the watch age limit
+	now := time.Now()
+	watched, short, old := gocql.TimeUUID(), gocql.TimeUUID(), gocql.TimeUUID()
+	watches := []playInfo{
+		{play_id: watched, profile_id: gocql.TimeUUID(), watchedAt: gocql.UUIDFromTime(now.Add(-time.Hour)), duration: l.minDuration},
+		{play_id: watched, profile_id: gocql.TimeUUID(), watchedAt: gocql.UUIDFromTime(now.Add(-2 * time.Hour)), duration: l.minDuration + 60},
+		{play_id: short, profile_id: gocql.TimeUUID(), watchedAt: gocql.UUIDFromTime(now.Add(-time.Hour)), duration: l.minDuration - 1},
+		{play_id: old, profile_id: gocql.TimeUUID(), watchedAt: gocql.UUIDFromTime(now.Add(-j.window - time.Hour)), duration: l.minDuration},
+	}
+
+	views := l.views(now, watches)
+	if len(views) != 2 {
+		t.Fatalf("views() returned %d views, want 2", len(views))
+	}
+
+	var events []trendingEvent
+	for _, view := range views {
+		events = append(events, trendingEvent{playID: view.play_id, profileID: view.profile_id, at: view.watchedAt})
+	}
+	scores := newTrendingScores(now, j.window, j.halfLife)
+	scores.add("views", events, j.viewWeight)
+	if scores.scores[watched] <= 0 {
+		t.Fatalf("score of the watched play = %v, want > 0", scores.scores[watched])
+	}
+
+	// A run after a finished one only logs the plays watched since, give or
+	// take the slack
+	l.Finished(now.Add(-90 * time.Minute))
+	if views := l.views(now, watches); len(views) != 1 || views[0].watchedAt != watches[0].watchedAt {
+		t.Fatalf("views() after a finished run = %v, want the play watched an hour ago", views)
+	}
+}
+
+func Test_trendingScores_add(t *testing.T) {
+	now := time.Now()
+	playID, profileID := gocql.TimeUUID(), gocql.TimeUUID()
+	at := gocql.UUIDFromTime(now.Add(-time.Hour))
+	view := trendingEvent{playID: playID, profileID: profileID, at: at}
+
+	scores := newTrendingScores(now, 48*time.Hour, 12*time.Hour)
+	scores.add("views", []trendingEvent{view}, 1)
+	once := scores.scores[playID]
+
+	// The same view logged twice and a redelivered like with another
+	// TIMEUUID of the same time are scored once
+	scores.add("views", []trendingEvent{view}, 1)
+	if got := scores.scores[playID]; got != once {
+		t.Fatalf("score after a duplicate view = %v, want %v", got, once)
+	}
+	like := trendingEvent{playID: playID, profileID: profileID, at: gocql.UUIDFromTime(at.Time())}
+	scores.add("likes", []trendingEvent{like}, 3)
+	scores.add("likes", []trendingEvent{{playID: playID, profileID: profileID, at: gocql.UUIDFromTime(at.Time())}}, 3)
+	if got, want := scores.scores[playID], once+decayedWeight(3, time.Hour, 12*time.Hour); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("score after a duplicate like = %v, want %v", got, want)
+	}
+
+	// Events of the first bucket that are older than the window are left out
+	stale := trendingEvent{playID: gocql.TimeUUID(), profileID: profileID, at: gocql.UUIDFromTime(now.Add(-49 * time.Hour))}
+	scores.add("views", []trendingEvent{stale}, 1)
+	if _, ok := scores.scores[stale.playID]; ok {
+		t.Fatal("event older than the window was scored")
+	}
+}
@@ -0,0 +1,318 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/arango"
+	"mashaghel/internal/database/scylla"
+	natsHelper "mashaghel/internal/helper/nats"
+	"mashaghel/internal/outbox"
+	"mashaghel/internal/repositories/models"
+	"time"
+
+	"github.com/arangodb/go-driver/v2/arangodb"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const viewCountsJobName = "view_counts"
+
+const (
+	// defaultViewCountsInterval is used when the job has no interval or
+	// schedule of its own
+	defaultViewCountsInterval = 5 * time.Minute
+	// defaultViewCountsSettle is how old a logged view has to be before it
+	// is counted
+	defaultViewCountsSettle = 10 * time.Second
+)
+
+const (
+	// viewLogShards is the number of shards of video_view_log, the views of
+	// a play always go to the same shard
+	viewLogShards     = 16
+	viewLogBucketSize = time.Hour
+	// viewLogRetention is the default_time_to_live of video_view_log, a view
+	// that is not counted by then is lost
+	viewLogRetention = 7 * 24 * time.Hour
+)
+
+// VideoViewedEvent is the event type of a VideoView, published on
+// VideoViewedSubject through the outbox when a view is recorded
+const (
+	VideoViewedEvent        = "video.viewed"
+	VideoViewedEventVersion = 1
+	VideoViewedSubject      = "views.recorded"
+)
+
+// VideoViewsCountedEvent is the event type of a VideoViewsCount, published
+// on VideoViewsCountedSubject through the outbox when views are added to a
+// video
+const (
+	VideoViewsCountedEvent        = "video.views_counted"
+	VideoViewsCountedEventVersion = 1
+	VideoViewsCountedSubject      = "views.counted"
+)
+
+func init() {
+	registerJob(viewCountsJobName, newViewCountsJob)
+	natsHelper.RegisterEvent(VideoViewedEvent, VideoViewedEventVersion, func() interface{} {
+		return &VideoView{}
+	})
+	natsHelper.RegisterEvent(VideoViewsCountedEvent, VideoViewsCountedEventVersion, func() interface{} {
+		return &VideoViewsCount{}
+	})
+}
+
+// VideoView is the payload of a video.viewed event
+type VideoView struct {
+	PlayID    string    `json:"play_id"`
+	ProfileID string    `json:"profile_id"`
+	WatchedAt time.Time `json:"watched_at"`
+	Duration  int       `json:"duration"` // Watched seconds
+}
+
+// VideoViewsCount is the payload of a video.views_counted event
+type VideoViewsCount struct {
+	PlayID   string `json:"play_id"`
+	Delta    int64  `json:"delta"`    // Views added to the video
+	Position string `json:"position"` // Log position the video is counted up to
+}
+
+const (
+	queryInsertVideoView    = `INSERT INTO video_views (play_id, watched_at, profile_id) VALUES (?, ?, ?);`
+	querySelectVideoView    = `SELECT play_id FROM video_views WHERE play_id = ? AND watched_at = ? AND profile_id = ?;`
+	queryInsertVideoViewLog = `INSERT INTO video_view_log (shard, bucket, id, play_id) VALUES (?, ?, ?, ?);`
+	querySelectVideoViewLog = `SELECT id, play_id FROM video_view_log WHERE shard = ? AND bucket = ? AND id > ? AND id < ? LIMIT ?;`
+	queryApplyVideoViews    = `FOR v IN @@collection FILTER v._key == @key AND (v.views_position || "") < @position UPDATE v WITH { views: v.views + @delta, views_position: @position } IN @@collection INSERT @event INTO @@outbox RETURN @delta`
+)
+
+// loggedView is a row of video_view_log
+type loggedView struct {
+	id     gocql.UUID
+	playID gocql.UUID
+}
+
+// videoViewDelta is the number of views of a play logged in a page of
+// video_view_log, up to the position of its last one
+type videoViewDelta struct {
+	playID   gocql.UUID
+	delta    int64
+	position string
+}
+
+// viewCountsJob adds the views logged in video_view_log to the views of the
+// videos in Arango. It reads every shard of the log forward from its
+// position in video_view_checkpoints. Every video keeps the log position it
+// counted up to in views_position, so a page that is counted again after a
+// failure adds nothing to the videos it already reached.
+type viewCountsJob struct {
+	arango arango.ArangoDB
+	logger *zap.Logger
+}
+
+func newViewCountsJob(t *task, _ config.JobConfig) (*Job, error) {
+	if t.arango == nil {
+		return nil, errors.New("view_counts needs arango to update the videos")
+	}
+
+	v := &viewCountsJob{
+		arango: t.arango,
+		logger: t.logger.With(zap.String("task", viewCountsJobName)),
+	}
+	scanner := &bucketScanner[loggedView]{
+		scylla:      t.scylla,
+		logger:      v.logger,
+		checkpoints: "video_view_checkpoints",
+		query:       querySelectVideoViewLog,
+		shards:      viewLogShards,
+		bucketSize:  viewLogBucketSize,
+		retention:   viewLogRetention,
+		pageSize:    t.ringScanConfig().PageSize,
+		settle:      defaultViewCountsSettle,
+		scan:        scanVideoViewLog,
+		handle:      v.applyViews,
+	}
+
+	return &Job{
+		Name:      viewCountsJobName,
+		Interval:  defaultViewCountsInterval,
+		Exclusive: true,
+		Run:       scanner.Run,
+	}, nil
+}
+
+func scanVideoViewLog(scanner gocql.Scanner) (gocql.UUID, loggedView, error) {
+	var view loggedView
+	err := scanner.Scan(&view.id, &view.playID)
+	return view.id, view, err
+}
+
+// viewLogShard returns the video_view_log shard of a play
+func viewLogShard(playID gocql.UUID) int {
+	h := fnv.New32a()
+	h.Write(playID.Bytes())
+	return int(h.Sum32() % viewLogShards)
+}
+
+// viewLogPosition orders the logged views of a play by when they were added
+func viewLogPosition(id gocql.UUID) string {
+	return fmt.Sprintf("%016x-%s", id.Timestamp(), id)
+}
+
+// videoViewDeltas counts the logged views of every play of a page, which are
+// in the order they were added
+func videoViewDeltas(views []loggedView) []videoViewDelta {
+	var deltas []videoViewDelta
+	indexes := make(map[gocql.UUID]int)
+	for _, view := range views {
+		i, ok := indexes[view.playID]
+		if !ok {
+			i = len(deltas)
+			indexes[view.playID] = i
+			deltas = append(deltas, videoViewDelta{playID: view.playID})
+		}
+		deltas[i].delta++
+		deltas[i].position = viewLogPosition(view.id)
+	}
+	return deltas
+}
+
+// applyViews adds the views of a page of the log to their videos, and their
+// video.views_counted event to the outbox in the same query. A page is only
+// processed once every video is updated.
+func (v *viewCountsJob) applyViews(ctx context.Context, _ int, views []loggedView) (int, error) {
+	for _, delta := range videoViewDeltas(views) {
+		document, err := videoViewsCountedDocument(ctx, delta)
+		if err != nil {
+			return 0, err
+		}
+		cursor, err := v.arango.Database(ctx).Query(ctx, queryApplyVideoViews, &arangodb.QueryOptions{
+			BindVars: map[string]interface{}{
+				"@collection": models.VideosCollection,
+				"@outbox":     outbox.Collection,
+				"key":         delta.playID.String(),
+				"delta":       delta.delta,
+				"position":    delta.position,
+				"event":       document,
+			},
+		})
+		if err != nil {
+			v.logger.Error("Failed to apply views", zap.Error(err), zap.String("playID", delta.playID.String()))
+			return 0, err
+		}
+
+		applied := cursor.HasMore()
+		cursor.Close()
+
+		if applied {
+			trace.SpanFromContext(ctx).AddEvent("views.applied", trace.WithAttributes(
+				attribute.String("views.play_id", delta.playID.String()),
+				attribute.Int64("views.delta", delta.delta),
+			))
+		}
+	}
+	return len(views), nil
+}
+
+// videoViewsCountedDocument returns the outbox document of the
+// video.views_counted event of a delta. The event ID is derived from the
+// position the video is counted up to, a delta is only applied once.
+func videoViewsCountedDocument(ctx context.Context, delta videoViewDelta) (*outbox.Document, error) {
+	event := natsHelper.NewEvent(ctx, VideoViewsCountedEvent, VideoViewsCountedEventVersion, &VideoViewsCount{
+		PlayID:   delta.playID.String(),
+		Delta:    delta.delta,
+		Position: delta.position,
+	})
+	event.ID = uuid.NewSHA1(uuid.NameSpaceOID, []byte("videos_collection/views/"+delta.playID.String()+"/"+delta.position)).String()
+	return outbox.NewDocument(VideoViewsCountedSubject, delta.playID.String(), event)
+}
+
+// viewRecorder records the moved watches that qualify as a view in
+// video_views, together with a row in video_view_log for view_counts and
+// their video.viewed event in the outbox. A view is keyed by its play,
+// profile and watched_at, and a view that is recorded already is skipped, so
+// recording a watch again does not count it twice.
+type viewRecorder struct {
+	scylla      scylla.ScyllaDB
+	minDuration int
+}
+
+// qualifiedViews returns the plays watched for at least minDuration seconds
+func qualifiedViews(playInfos map[gocql.UUID]playInfo, minDuration int) []playInfo {
+	var views []playInfo
+	for _, info := range playInfos {
+		if info.duration >= minDuration {
+			views = append(views, info)
+		}
+	}
+	return views
+}
+
+// Record writes the views among the plays that did not fail in a previous
+// step and returns the plays it failed to write, keyed like the batches
+func (r *viewRecorder) Record(ctx context.Context, playInfos map[gocql.UUID]playInfo, failed map[string]error) map[string]error {
+	errs := make(map[string]error)
+	for _, info := range qualifiedViews(playInfos, r.minDuration) {
+		key := info.play_id.String()
+		if _, ok := failed[key]; ok {
+			continue
+		}
+		// The batch of a view writes its log row and event too, so a
+		// recorded view already has them
+		recorded, err := r.recorded(ctx, info)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		if recorded {
+			continue
+		}
+
+		// The views of a profile go to the partitions of their plays, so
+		// every view is a batch of its own
+		batch := r.scylla.Session().NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		if err := addView(ctx, batch, info); err != nil {
+			errs[key] = err
+			continue
+		}
+		if err := r.scylla.Session().ExecuteBatch(batch); err != nil {
+			errs[key] = err
+		}
+	}
+	return errs
+}
+
+func (r *viewRecorder) recorded(ctx context.Context, info playInfo) (bool, error) {
+	var playID gocql.UUID
+	err := r.scylla.Session().Query(querySelectVideoView, info.play_id, info.watchedAt, info.profile_id).
+		WithContext(ctx).
+		Scan(&playID)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// addView adds a view, its log row and its video.viewed event to a logged
+// batch. The event ID is derived from the view, so the event of a view
+// recorded again is dropped by the stream as a duplicate.
+func addView(ctx context.Context, batch *gocql.Batch, info playInfo) error {
+	batch.Query(queryInsertVideoView, info.play_id, info.watchedAt, info.profile_id)
+	id := gocql.TimeUUID()
+	batch.Query(queryInsertVideoViewLog, viewLogShard(info.play_id), id.Time().UTC().Truncate(viewLogBucketSize), id, info.play_id)
+
+	event := natsHelper.NewEvent(ctx, VideoViewedEvent, VideoViewedEventVersion, &VideoView{
+		PlayID:    info.play_id.String(),
+		ProfileID: info.profile_id.String(),
+		WatchedAt: info.watchedAt.Time(),
+		Duration:  info.duration,
+	})
+	event.ID = uuid.NewSHA1(uuid.NameSpaceOID, []byte("video_views/"+info.play_id.String()+"/"+info.profile_id.String()+"/"+info.watchedAt.String())).String()
+	return outbox.Add(batch, VideoViewedSubject, info.play_id.String(), event)
+}
@@ -0,0 +1,136 @@
+package tasks
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.uber.org/zap"
+)
+
+func Test_addView(t *testing.T) {
+	info := playInfo{
+		play_id:    gocql.TimeUUID(),
+		profile_id: gocql.TimeUUID(),
+		watchedAt:  gocql.UUIDFromTime(time.Now().Add(-time.Hour)),
+		duration:   120,
+	}
+
+	batch := &gocql.Batch{Type: gocql.LoggedBatch}
+	if err := addView(context.Background(), batch, info); err != nil {
+		t.Fatalf("addView() error = %v", err)
+	}
+	if batch.Size() != 3 {
+		t.Fatalf("batch has %d statements, want the view, its log row and its event", batch.Size())
+	}
+
+	logged := batch.Entries[1].Args
+	if logged[0] != viewLogShard(info.play_id) || logged[3] != info.play_id {
+		t.Fatalf("addView() logged %v, want the view of play %s", logged, info.play_id)
+	}
+
+	// Relay the outbox row the batch writes
+	args := batch.Entries[2].Args
+	entry := outboxEntry{
+		Shard:        args[0].(int),
+		Bucket:       args[1].(time.Time),
+		ID:           args[2].(gocql.UUID),
+		AggregateKey: args[3].(string),
+		Subject:      args[4].(string),
+		Event:        args[5].([]byte),
+	}
+	conn := &fakeNats{}
+	r := &outboxRelayJob{nats: conn, logger: zap.NewNop()}
+	delivered, err := r.relay(context.Background(), []outboxEntry{entry})
+	if err != nil || delivered != 1 {
+		t.Fatalf("relay() = %d, %v, want the event delivered", delivered, err)
+	}
+
+	if len(conn.published) != 1 || conn.subjects[0] != VideoViewedSubject {
+		t.Fatalf("published %d events on %v, want one on %s", len(conn.published), conn.subjects, VideoViewedSubject)
+	}
+	view, ok := conn.published[0].Payload.(*VideoView)
+	if !ok || conn.published[0].Type != VideoViewedEvent {
+		t.Fatalf("published a %s event with %T, want %s", conn.published[0].Type, conn.published[0].Payload, VideoViewedEvent)
+	}
+	if view.PlayID != info.play_id.String() || view.ProfileID != info.profile_id.String() || view.Duration != info.duration {
+		t.Fatalf("published %+v, want the view of %+v", view, info)
+	}
+
+	again := &gocql.Batch{Type: gocql.LoggedBatch}
+	if err := addView(context.Background(), again, info); err != nil {
+		t.Fatalf("addView() error = %v", err)
+	}
+	if _, err := r.relay(context.Background(), []outboxEntry{{
+		ID:           gocql.TimeUUID(),
+		AggregateKey: again.Entries[2].Args[3].(string),
+		Subject:      again.Entries[2].Args[4].(string),
+		Event:        again.Entries[2].Args[5].([]byte),
+	}}); err != nil {
+		t.Fatalf("relay() error = %v", err)
+	}
+	if conn.published[1].ID != conn.published[0].ID {
+		t.Fatalf("recording the view again published the event %s, want %s", conn.published[1].ID, conn.published[0].ID)
+	}
+}
+
+func Test_videoViewDeltas(t *testing.T) {
+	a, b := gocql.TimeUUID(), gocql.TimeUUID()
+	views := []loggedView{
+		{id: gocql.TimeUUID(), playID: a},
+		{id: gocql.TimeUUID(), playID: b},
+		{id: gocql.TimeUUID(), playID: a},
+	}
+
+	deltas := videoViewDeltas(views)
+
+	want := []videoViewDelta{
+		{playID: a, delta: 2, position: viewLogPosition(views[2].id)},
+		{playID: b, delta: 1, position: viewLogPosition(views[1].id)},
+	}
+	if !reflect.DeepEqual(deltas, want) {
+		t.Fatalf("videoViewDeltas() = %+v, want %+v", deltas, want)
+	}
+	// A page counted again has the same positions, which the videos that
+	// counted it already are not behind
+	if viewLogPosition(views[0].id) >= viewLogPosition(views[2].id) {
+		t.Fatalf("position of %s is not before the position of %s", views[0].id, views[2].id)
+	}
+}
+
+func Test_videoViewsCountedDocument(t *testing.T) {
+	delta := videoViewDelta{playID: gocql.TimeUUID(), delta: 3, position: viewLogPosition(gocql.TimeUUID())}
+
+	document, err := videoViewsCountedDocument(context.Background(), delta)
+	if err != nil {
+		t.Fatalf("videoViewsCountedDocument() error = %v", err)
+	}
+	if document.AggregateKey != delta.playID.String() || document.Subject != VideoViewsCountedSubject {
+		t.Fatalf("videoViewsCountedDocument() = %+v, want an event of play %s on %s", document, delta.playID, VideoViewsCountedSubject)
+	}
+
+	// Relay the document the query inserts
+	conn := &fakeNats{}
+	r := &outboxRelayJob{nats: conn, logger: zap.NewNop()}
+	delivered, err := r.relay(context.Background(), []outboxEntry{arangoOutboxEntry(*document)})
+	if err != nil || delivered != 1 {
+		t.Fatalf("relay() = %d, %v, want the event delivered", delivered, err)
+	}
+	count, ok := conn.published[0].Payload.(*VideoViewsCount)
+	if !ok || conn.published[0].Type != VideoViewsCountedEvent || conn.published[0].ID != document.Key {
+		t.Fatalf("published a %s event %s with %T, want the %s event %s", conn.published[0].Type, conn.published[0].ID, conn.published[0].Payload, VideoViewsCountedEvent, document.Key)
+	}
+	if count.PlayID != delta.playID.String() || count.Delta != delta.delta || count.Position != delta.position {
+		t.Fatalf("published %+v, want the views of %+v", count, delta)
+	}
+
+	again, err := videoViewsCountedDocument(context.Background(), delta)
+	if err != nil {
+		t.Fatalf("videoViewsCountedDocument() error = %v", err)
+	}
+	if again.Key != document.Key {
+		t.Fatalf("the same delta has the event %s, want %s", again.Key, document.Key)
+	}
+}
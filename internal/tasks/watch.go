@@ -1,20 +1,52 @@
 package tasks
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"errors"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/scylla"
+	natsHelper "mashaghel/internal/helper/nats"
 	"sync"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/gofrs/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+const watchJobName = "watch"
+
+// WatchMovedEvent is the event type of a WatchMove, published on
+// WatchMovedSubject through the outbox when a play is moved to ordered_watch
+const (
+	WatchMovedEvent        = "watch.moved"
+	WatchMovedEventVersion = 1
+	WatchMovedSubject      = "watches.moved"
+)
+
+func init() {
+	registerJob(watchJobName, newWatchJob)
+	natsHelper.RegisterEvent(WatchMovedEvent, WatchMovedEventVersion, func() interface{} {
+		return &WatchMove{}
+	})
+}
+
+// WatchMove is the payload of a watch.moved event
+type WatchMove struct {
+	ProfileID string    `json:"profile_id"`
+	PlayID    string    `json:"play_id"`
+	WatchedAt time.Time `json:"watched_at"`
+	Duration  int       `json:"duration"` // Watched seconds
+}
+
 type playInfo struct {
 	watchedAt  gocql.UUID
 	duration   int
-	profile_id string
-	play_id    string
+	profile_id gocql.UUID
+	play_id    gocql.UUID
 }
 
 const (
@@ -22,173 +54,458 @@ const (
 	queryInsertOrderedWatch         = `INSERT INTO ordered_watch (profile_id, play_id, duration, watched_at) VALUES (?, ?, ?, ?);`
 	queryDeleteOutdatedOrderedWatch = `DELETE FROM ordered_watch WHERE profile_id = ? AND watched_at = ?;`
 	queryDeleteOutdatedRecentWatch  = `DELETE FROM recent_watch USING TIMESTAMP ? WHERE profile_id = ? AND play_id = ?;`
+	querySelectWatched              = `SELECT play_id, watched_at FROM watched WHERE profile_id = ? AND play_id IN ?;`
+	querySelectWatchedByKey         = `SELECT watched_at FROM watched WHERE profile_id = ? AND play_id = ?;`
 )
 
-func (t *task) processWatched() {
-	profileToken := int64(0)
-	var err error
-	var playInfos map[string]playInfo
-	wg := sync.WaitGroup{}
-	daysAgo := time.Now().Add(time.Duration(t.configs.TasksConfig.WatchAgeLimit) * time.Hour)
-
-	t.logger.Info("Starting processWatched task Powered by (YA ALI)")
-
-	for {
-		t.logger.Info("Processing idle watches", zap.Int64("profileToken", profileToken))
-		playInfos, profileToken, err = t.processIdleWatches(profileToken, daysAgo)
-		if err != nil {
-			t.logger.Error("Error processing idle watches", zap.Error(err))
-			time.Sleep(time.Second * 10)
-			continue
+const (
+	watchLookupIn     = "in"
+	watchLookupPerKey = "per_key"
+
+	// watchLookupConcurrency caps the concurrent per key queries of a profile
+	watchLookupConcurrency = 8
+)
+
+const querySelectRecentWatches = `SELECT token(profile_id), play_id, watched_at, duration, profile_id FROM recent_watch WHERE token(profile_id) > ? AND token(profile_id) <= ?;`
+
+// watchJob moves idle rows of recent_watch into watched and ordered_watch
+type watchJob struct {
+	scylla  scylla.ScyllaDB
+	logger  *zap.Logger
+	configs *config.TasksConfig
+	batches *batchWriter
+	retry   retryPolicy
+	metrics *jobMetrics
+	// deadLetters keeps the plays that failed every attempt
+	deadLetters *deadLetterStore
+	// views records the moved plays for the view_counts job, nil while it is
+	// disabled
+	views *viewRecorder
+	// trendingViews logs the recent plays for the trending job, nil while it
+	// is disabled or does not score views
+	trendingViews *trendingViewLog
+	// report collects what a dry run would write, nothing is written while
+	// it is set
+	report *WatchReport
+}
+
+func newWatchJob(t *task, cfg config.JobConfig) (*Job, error) {
+	w, err := newWatchJobRunner(t, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{
+		Name:      watchJobName,
+		Interval:  time.Duration(w.configs.WatchCooldownDuration) * time.Second,
+		Exclusive: true,
+		Run:       w.run(t),
+		Replay:    w.replay,
+		DryRun: func(ctx context.Context, workers *Workers) (any, error) {
+			return dryRunWatch(ctx, t, cfg, workers)
+		},
+	}, nil
+}
+
+func newWatchJobRunner(t *task, cfg config.JobConfig) (*watchJob, error) {
+	w := &watchJob{
+		scylla:      t.scylla,
+		logger:      t.logger.With(zap.String("task", "watched")),
+		configs:     &t.configs.TasksConfig,
+		retry:       newRetryPolicy(cfg.Retry),
+		metrics:     t.metrics,
+		deadLetters: t.deadLetters,
+	}
+	batches, err := newBatchWriter(t.scylla, t.configs.TasksConfig.WatchBatch, watchJobName, t.metrics, w.logger)
+	if err != nil {
+		return nil, err
+	}
+	w.batches = batches
+	jobs := t.configs.TasksConfig.Jobs
+	if jobs[viewCountsJobName].Enabled {
+		w.views = &viewRecorder{
+			scylla:      t.scylla,
+			minDuration: t.configs.TasksConfig.ViewCounts.MinDuration,
 		}
-		if playInfos == nil {
-			t.logger.Info("No idle watches found, job is done")
-			wg.Wait()
-			return
+	}
+	if trending := newTrending(t.configs.TasksConfig); jobs[trendingJobName].Enabled && trending.viewWeight != 0 {
+		w.trendingViews = &trendingViewLog{
+			scylla:      t.scylla,
+			minDuration: t.configs.TasksConfig.ViewCounts.MinDuration,
+			window:      trending.window,
 		}
-		t.logger.Info("Idle watches found", zap.Int("count", len(playInfos)))
-		wg.Add(1)
-		t.workerpool.Submit(func() {
-			t.updateWatches(playInfos, profileToken)
-			wg.Done()
-		})
 	}
+	return w, nil
 }
 
-func (t *task) updateWatches(playInfos map[string]playInfo, profileToken int64) {
-	t.logger.Info("Starting updateWatches", zap.Int64("profileToken", profileToken), zap.Int("playInfosCount", len(playInfos)))
+// run returns the run of the job, a scan of recent_watch. The trending views
+// are logged from where the last run that finished started.
+func (w *watchJob) run(t *task) func(ctx context.Context, workers *Workers) error {
+	scanner := w.scanner(t)
+	return func(ctx context.Context, workers *Workers) error {
+		started := time.Now()
+		if err := scanner.Run(ctx, workers); err != nil {
+			return err
+		}
+		if w.trendingViews != nil {
+			w.trendingViews.Finished(started)
+		}
+		return nil
+	}
+}
 
-	batch := t.scylla.Session().NewBatch(gocql.LoggedBatch)
-	deleteTS := time.Now().UnixNano() / 1000 // this is for deleting recent_watches
-	batch.SetConsistency(gocql.Any)
+func (w *watchJob) scanner(t *task) *ringScanner[playInfo] {
+	return &ringScanner[playInfo]{
+		name:        watchJobName,
+		scylla:      t.scylla,
+		logger:      w.logger,
+		checkpoints: t.checkpoints,
+		configs:     t.ringScanConfig(),
+		query:       querySelectRecentWatches,
+		scan:        scanRecentWatch,
+		handle:      w.processWatched,
+	}
+}
+
+func scanRecentWatch(scanner gocql.Scanner) (int64, playInfo, error) {
+	var token int64
+	var info playInfo
+	err := scanner.Scan(&token, &info.play_id, &info.watchedAt, &info.duration, &info.profile_id)
+	return token, info, err
+}
+
+// processWatched moves the idle watches of a single recent_watch partition
+func (t *watchJob) processWatched(ctx context.Context, run scanRun, profileToken int64, rows []playInfo) error {
+	daysAgo := run.StartedAt.Add(time.Duration(t.configs.WatchAgeLimit) * time.Hour)
+
+	// Profiles whose ids collide on the same token share a partition scan
+	profiles := make(map[gocql.UUID][]playInfo)
+	for _, row := range rows {
+		profiles[row.profile_id] = append(profiles[row.profile_id], row)
+	}
+
+	for profileID, profileRows := range profiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if t.report != nil {
+			t.report.addProfile(run.StartedAt, profileRows)
+		} else if t.trendingViews != nil {
+			if err := t.trendingViews.Record(ctx, run.StartedAt, profileRows); err != nil {
+				t.logger.Error("Failed to log trending views", zap.Error(err), zap.String("profileID", profileID.String()))
+				return err
+			}
+		}
+		t.metrics.ProfilesScanned(ctx, watchJobName, 1)
 
-	placeHolder := ""
-	i := 0
+		playInfos := t.processIdleWatches(ctx, profileRows, daysAgo)
+		if len(playInfos) == 0 {
+			continue
+		}
 
-	for playId := range playInfos {
-		if i == 0 {
-			placeHolder += playId
-		} else {
-			placeHolder += "," + playId
+		t.logger.Info("Idle watches found", zap.Int("count", len(playInfos)), zap.Int64("profileToken", profileToken))
+		if err := t.moveWatches(ctx, profileID, playInfos); err != nil {
+			return err
 		}
-		i++
+	}
+	return nil
+}
+
+// moveWatches updates the watches of a profile under the retry policy of the
+// job and dead-letters the plays that fail every attempt. It only returns an
+// error when the plays could neither be moved nor dead-lettered.
+func (t *watchJob) moveWatches(ctx context.Context, profileID gocql.UUID, playInfos map[gocql.UUID]playInfo) error {
+	pending := playInfos
+	var attempts int
+	err := t.retry.Do(ctx, func(attempt int) error {
+		attempts = attempt
+		failed, err := t.updateWatches(ctx, profileID, pending)
+		pending = failed
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		// The range is scanned again from its checkpoint
+		return ctx.Err()
+	}
+	if t.report != nil {
+		t.report.addError(profileID, err)
+		return nil
+	}
+
+	t.logger.Error("Giving up on watches, dead-lettering them",
+		zap.Error(err),
+		zap.String("profileID", profileID.String()),
+		zap.Int("plays", len(pending)),
+		zap.Int("attempts", attempts),
+	)
+	payload, marshalErr := json.Marshal(newWatchDeadLetter(profileID, pending))
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return t.deadLetters.Add(ctx, &DeadLetter{
+		JobName:  watchJobName,
+		ItemKey:  profileID.String(),
+		Payload:  payload,
+		Error:    err.Error(),
+		Attempts: attempts,
+	})
+}
+
+// updateWatches moves the given plays of a profile and returns the plays that
+// have to be tried again
+func (t *watchJob) updateWatches(ctx context.Context, profileID gocql.UUID, playInfos map[gocql.UUID]playInfo) (map[gocql.UUID]playInfo, error) {
+	t.logger.Info("Starting updateWatches", zap.String("profileID", profileID.String()), zap.Int("playInfosCount", len(playInfos)))
+
+	if err := ctx.Err(); err != nil {
+		t.logger.Warn("Run is cancelled, skipping updateWatches", zap.String("profileID", profileID.String()))
+		return playInfos, err
+	}
+
+	if len(playInfos) == 0 {
+		t.logger.Warn("No playInfos to process for the given profile", zap.String("profileID", profileID.String()))
+		return nil, nil
 	}
 
-	if i == 0 {
-		t.logger.Warn("No playInfos to process for the given token", zap.Int64("profileToken", profileToken))
-		return
+	playIDs := make([]gocql.UUID, 0, len(playInfos))
+	for playID := range playInfos {
+		playIDs = append(playIDs, playID)
+	}
+
+	var watched map[gocql.UUID]gocql.UUID
+	var err error
+	switch t.configs.WatchLookup {
+	case watchLookupPerKey:
+		watched, err = t.lookupWatchedPerKey(ctx, profileID, playIDs)
+	default: // watchLookupIn
+		watched, err = t.lookupWatched(ctx, profileID, playIDs)
+	}
+	if err != nil {
+		t.logger.Error("Error querying watched table", zap.Error(err), zap.String("profileID", profileID.String()))
+		return playInfos, err
 	}
 
-	t.logger.Info("Querying watched table", zap.String("placeHolder", placeHolder))
+	if t.report != nil {
+		t.report.addMoves(profileID, playInfos, watched)
+		return nil, nil
+	}
 
-	var watchedAt gocql.UUID
-	var play_id string
+	deleteTS := time.Now().UnixNano() / 1000 // this is for deleting recent_watches
 
-	query := fmt.Sprintf(`SELECT play_id, watched_at FROM watched WHERE token(profile_id) = ? AND play_id in (%v)`, placeHolder)
-	iter := t.scylla.Session().Query(query, profileToken).Consistency(gocql.One).Iter()
-	for iter.Scan(&play_id, &watchedAt) {
-		playInfo, ok := playInfos[play_id]
+	// Rows are moved table by table so that every chunk stays in a single
+	// partition. A play is only deleted from recent_watch once it is written
+	// to watched and ordered_watch.
+	var watchedUpdates, orderedInserts, orderedDeletes, recentDeletes []batchStatement
+	moving := make(map[gocql.UUID]playInfo, len(watched))
+	for playID, watchedAt := range watched {
+		playInfo, ok := playInfos[playID]
 
 		if !ok {
-			t.logger.Error("Play info not found", zap.String("play_id", play_id))
+			t.logger.Error("Play info not found", zap.String("play_id", playID.String()))
 			continue
 		}
 
-		t.logger.Info("Processing play info", zap.String("play_id", play_id), zap.String("profile_id", playInfo.profile_id))
+		t.logger.Info("Processing play info", zap.String("play_id", playID.String()), zap.String("profile_id", profileID.String()))
 
-		batch.Query(
-			queryUpdateWatched,
-			playInfo.watchedAt,
-			playInfo.profile_id,
-			playInfo.play_id,
-		)
-		batch.Query(
-			queryInsertOrderedWatch,
-			playInfo.profile_id,
-			playInfo.play_id,
-			playInfo.duration,
-			playInfo.watchedAt,
-		)
-		if watchedAt != gocql.UUID(uuid.Nil) {
-			batch.Query(queryDeleteOutdatedOrderedWatch, playInfo.profile_id, watchedAt)
+		moving[playID] = playInfo
+		key := playID.String()
+		watchedUpdates = append(watchedUpdates, batchStatement{
+			Key:   key,
+			Query: queryUpdateWatched,
+			Args:  []interface{}{playInfo.watchedAt, playInfo.profile_id, playInfo.play_id},
+		})
+		orderedInserts = append(orderedInserts, batchStatement{
+			Key:   key,
+			Query: queryInsertOrderedWatch,
+			Args:  []interface{}{playInfo.profile_id, playInfo.play_id, playInfo.duration, playInfo.watchedAt},
+			Event: watchMovedEvent(ctx, playInfo),
+		})
+		if watchedAt != gocql.UUID(uuid.Nil) && watchedAt != playInfo.watchedAt {
+			orderedDeletes = append(orderedDeletes, batchStatement{
+				Key:   key,
+				Query: queryDeleteOutdatedOrderedWatch,
+				Args:  []interface{}{playInfo.profile_id, watchedAt},
+			})
 		}
-		batch.Query(queryDeleteOutdatedRecentWatch, deleteTS, playInfo.profile_id, playInfo.play_id)
+		recentDeletes = append(recentDeletes, batchStatement{
+			Key:   key,
+			Query: queryDeleteOutdatedRecentWatch,
+			Args:  []interface{}{deleteTS, playInfo.profile_id, playInfo.play_id},
+		})
 	}
 
-	if err := t.scylla.Session().ExecuteBatch(batch); err != nil {
-		t.logger.Error("Error executing batch", zap.Error(err), zap.String("placeHolder", placeHolder))
-		return
+	if len(recentDeletes) == 0 {
+		t.logger.Warn("None of the plays exist in watched table", zap.String("profileID", profileID.String()))
+		return nil, nil
 	}
 
-	if err := iter.Close(); err != nil {
-		t.logger.Error("Error closing iterator", zap.Error(err))
-		return
+	failed := t.batches.Write(ctx, watchedUpdates)
+	for key, err := range t.batches.Write(ctx, orderedInserts) {
+		failed[key] = err
+	}
+	if t.views != nil {
+		for key, err := range t.views.Record(ctx, moving, failed) {
+			failed[key] = err
+		}
+	}
+	if len(failed) > 0 {
+		t.logger.Error("Failed to move watches, keeping them in recent_watch",
+			zap.String("profileID", profileID.String()),
+			zap.Int("failed", len(failed)),
+		)
+	}
+
+	t.batches.Write(ctx, withoutFailed(orderedDeletes, failed))
+	for key, err := range t.batches.Write(ctx, withoutFailed(recentDeletes, failed)) {
+		failed[key] = err
+	}
+	t.metrics.RowsMoved(ctx, watchJobName, len(recentDeletes)-len(failed))
+	trace.SpanFromContext(ctx).AddEvent("watch.moved", trace.WithAttributes(
+		attribute.String("watch.profile_id", profileID.String()),
+		attribute.Int("watch.plays", len(recentDeletes)),
+		attribute.Int("watch.failed", len(failed)),
+	))
+	if len(failed) > 0 {
+		retry := make(map[gocql.UUID]playInfo, len(failed))
+		seen := make(map[string]bool)
+		var errs []error
+		for key, err := range failed {
+			playID, _ := gocql.ParseUUID(key)
+			retry[playID] = playInfos[playID]
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				errs = append(errs, err)
+			}
+		}
+		return retry, errors.Join(errs...)
 	}
 
 	t.logger.Info("Successfully updated watches")
+	return nil, nil
 }
 
-func (t *task) processIdleWatches(token int64, daysAgo time.Time) (map[string]playInfo, int64, error) {
-	t.logger.Info("Starting processIdleWatches", zap.Int64("token", token))
+// watchMovedEvent returns the watch.moved event of a play. The event ID is
+// derived from the play, so the event of a play moved again is dropped by the
+// stream as a duplicate.
+func watchMovedEvent(ctx context.Context, info playInfo) *batchEvent {
+	event := natsHelper.NewEvent(ctx, WatchMovedEvent, WatchMovedEventVersion, &WatchMove{
+		ProfileID: info.profile_id.String(),
+		PlayID:    info.play_id.String(),
+		WatchedAt: info.watchedAt.Time(),
+		Duration:  info.duration,
+	})
+	event.ID = uuid.NewV5(uuid.NamespaceOID, "ordered_watch/"+info.profile_id.String()+"/"+info.play_id.String()+"/"+info.watchedAt.String()).String()
+	return &batchEvent{
+		Subject:      WatchMovedSubject,
+		AggregateKey: info.profile_id.String(),
+		Event:        event,
+	}
+}
 
-	playIds := make(map[string]playInfo)
-	if token == 0 {
-		query := `SELECT DISTINCT token(profile_id) FROM recent_watch LIMIT 1;`
+// withoutFailed drops the statements of the items that failed in a previous
+// step
+func withoutFailed(statements []batchStatement, failed map[string]error) []batchStatement {
+	if len(failed) == 0 {
+		return statements
+	}
 
-		if err := t.scylla.Session().Query(query).Scan(&token); err != nil {
-			if err == gocql.ErrNotFound {
-				t.logger.Info("No initial token found, retrying...")
-				return nil, token, nil
-			}
-			t.logger.Error("Error fetching profile ID", zap.Error(err))
-			return nil, token, err
-		}
-	} else {
-		query := `SELECT DISTINCT token(profile_id) FROM recent_watch WHERE token(profile_id) > ? LIMIT 1;`
-		if err := t.scylla.Session().Query(query, token).Consistency(gocql.One).Scan(&token); err != nil {
-			if err == gocql.ErrNotFound {
-				t.logger.Info("No next token found, retrying...")
-				return nil, token, nil
-			}
-			t.logger.Error("Error fetching next token", zap.Error(err))
-			return nil, token, err
+	kept := statements[:0:0]
+	for _, statement := range statements {
+		if _, ok := failed[statement.Key]; !ok {
+			kept = append(kept, statement)
 		}
 	}
+	return kept
+}
+
+// lookupWatched returns the current watched_at of the given plays of a
+// profile in a single prepared single-partition query
+func (t *watchJob) lookupWatched(ctx context.Context, profileID gocql.UUID, playIDs []gocql.UUID) (map[gocql.UUID]gocql.UUID, error) {
+	return selectWatched(ctx, t.scylla, profileID, playIDs)
+}
+
+func selectWatched(ctx context.Context, scyllaDB scylla.ScyllaDB, profileID gocql.UUID, playIDs []gocql.UUID) (map[gocql.UUID]gocql.UUID, error) {
+	watched := make(map[gocql.UUID]gocql.UUID, len(playIDs))
+
+	var playID, watchedAt gocql.UUID
+	iter := scyllaDB.Session().Query(querySelectWatched, profileID, playIDs).
+		WithContext(ctx).
+		Consistency(gocql.One).
+		Iter()
+	for iter.Scan(&playID, &watchedAt) {
+		watched[playID] = watchedAt
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return watched, nil
+}
 
-	t.logger.Info("Querying play IDs for token", zap.Int64("token", token))
+// lookupWatchedPerKey returns the current watched_at of the given plays of a
+// profile with one prepared query per play, run concurrently
+func (t *watchJob) lookupWatchedPerKey(ctx context.Context, profileID gocql.UUID, playIDs []gocql.UUID) (map[gocql.UUID]gocql.UUID, error) {
+	watched := make(map[gocql.UUID]gocql.UUID, len(playIDs))
 
-	query := `SELECT play_id, watched_at, duration, profile_id FROM recent_watch WHERE token(profile_id) = ?;`
-	iter := t.scylla.Session().Query(query, token).Consistency(gocql.One).Iter()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	sem := make(chan struct{}, watchLookupConcurrency)
+	for _, playID := range playIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			var watchedAt gocql.UUID
+			err := t.scylla.Session().Query(querySelectWatchedByKey, profileID, playID).
+				WithContext(ctx).
+				Consistency(gocql.One).
+				Scan(&watchedAt)
 
-	var playId string
-	var profile_id string
-	var duration int
-	var watchedAt gocql.UUID
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == gocql.ErrNotFound:
+			case err != nil:
+				errs = append(errs, err)
+			default:
+				watched[playID] = watchedAt
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return watched, nil
+}
 
-	for iter.Scan(&playId, &watchedAt, &duration, &profile_id) {
-		isOlderThanAgeLimit := watchedAt.Time().Before(daysAgo)
+// processIdleWatches keeps the latest watch of every play that is older than
+// the age limit. Nothing is kept once ctx is done.
+func (t *watchJob) processIdleWatches(ctx context.Context, rows []playInfo, daysAgo time.Time) map[gocql.UUID]playInfo {
+	playIds := make(map[gocql.UUID]playInfo)
+
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			return nil
+		}
+		isOlderThanAgeLimit := row.watchedAt.Time().Before(daysAgo)
 		if !isOlderThanAgeLimit {
 			continue
 		}
 
-		if watchedAt.Time().Before(daysAgo) {
-			existingPlayInfo, exists := playIds[playId]
-			if !exists || watchedAt.Time().After(existingPlayInfo.watchedAt.Time()) {
-				playIds[playId] = playInfo{
-					profile_id: profile_id,
-					play_id:    playId,
-					watchedAt:  watchedAt,
-					duration:   duration,
-				}
-			}
+		existingPlayInfo, exists := playIds[row.play_id]
+		if !exists || row.watchedAt.Time().After(existingPlayInfo.watchedAt.Time()) {
+			playIds[row.play_id] = row
 		}
 	}
 
-	if err := iter.Close(); err != nil {
-		t.logger.Error("Error fetching play IDs", zap.Error(err))
-		return nil, token, err
-	}
-
-	t.logger.Info("Successfully processed idle watches", zap.Int("playIdsCount", len(playIds)))
-	return playIds, token, nil
+	return playIds
 }
@@ -0,0 +1,81 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gocql/gocql"
+	"go.uber.org/zap"
+)
+
+const querySelectRecentWatchByKey = `SELECT watched_at, duration FROM recent_watch WHERE profile_id = ? AND play_id = ?;`
+
+// watchDeadLetter is the payload of a dead-lettered profile of the watch job
+type watchDeadLetter struct {
+	ProfileID gocql.UUID            `json:"profile_id"`
+	Plays     []watchDeadLetterPlay `json:"plays"`
+}
+
+type watchDeadLetterPlay struct {
+	PlayID    gocql.UUID `json:"play_id"`
+	WatchedAt gocql.UUID `json:"watched_at"`
+	Duration  int        `json:"duration"`
+}
+
+func newWatchDeadLetter(profileID gocql.UUID, playInfos map[gocql.UUID]playInfo) watchDeadLetter {
+	dl := watchDeadLetter{ProfileID: profileID}
+	for _, info := range playInfos {
+		dl.Plays = append(dl.Plays, watchDeadLetterPlay{
+			PlayID:    info.play_id,
+			WatchedAt: info.watchedAt,
+			Duration:  info.duration,
+		})
+	}
+	return dl
+}
+
+// replay moves the plays of a dead-lettered profile. Plays that were watched
+// again or already left recent_watch since are skipped, the job handles them
+// on its own.
+func (t *watchJob) replay(ctx context.Context, payload json.RawMessage) error {
+	var dl watchDeadLetter
+	if err := json.Unmarshal(payload, &dl); err != nil {
+		return err
+	}
+
+	playInfos := make(map[gocql.UUID]playInfo, len(dl.Plays))
+	for _, play := range dl.Plays {
+		var watchedAt gocql.UUID
+		var duration int
+		err := t.scylla.Session().Query(querySelectRecentWatchByKey, dl.ProfileID, play.PlayID).
+			WithContext(ctx).
+			Scan(&watchedAt, &duration)
+		if err == gocql.ErrNotFound || (err == nil && watchedAt != play.WatchedAt) {
+			t.logger.Info("Dead-lettered play changed since, skipping it",
+				zap.String("profileID", dl.ProfileID.String()),
+				zap.String("playID", play.PlayID.String()),
+			)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		playInfos[play.PlayID] = playInfo{
+			watchedAt:  watchedAt,
+			duration:   duration,
+			profile_id: dl.ProfileID,
+			play_id:    play.PlayID,
+		}
+	}
+	if len(playInfos) == 0 {
+		return nil
+	}
+
+	pending := playInfos
+	return t.retry.Do(ctx, func(int) error {
+		failed, err := t.updateWatches(ctx, dl.ProfileID, pending)
+		pending = failed
+		return err
+	})
+}
@@ -0,0 +1,179 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"mashaghel/internal/config"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/gofrs/uuid"
+)
+
+// maxReportOutliers caps the outliers kept in a report, the rest are only
+// counted
+const maxReportOutliers = 1000
+
+const (
+	OutlierFutureWatchedAt  = "future_watched_at"  // Watched after the run started
+	OutlierInvalidDuration  = "invalid_duration"   // Duration is zero or negative
+	OutlierNotInWatched     = "not_in_watched"     // Idle play without a row in watched, it is never moved
+	OutlierOlderThanWatched = "older_than_watched" // Moving the play would set an older watched_at
+	OutlierLargeProfile     = "large_profile"      // More idle plays than a single batch holds
+)
+
+// WatchReport is what a run of the watch job would write
+type WatchReport struct {
+	RunStartedAt           time.Time      `json:"run_started_at"`
+	FinishedAt             time.Time      `json:"finished_at"`
+	AgeLimit               string         `json:"age_limit"`
+	ProfilesScanned        int64          `json:"profiles_scanned"`
+	RowsScanned            int64          `json:"rows_scanned"`
+	ProfilesWithIdleWatch  int64          `json:"profiles_with_idle_watch"`
+	RowsMovedToOrdered     int64          `json:"rows_moved_to_ordered_watch"`
+	OutdatedOrderedDeleted int64          `json:"outdated_ordered_watch_deleted"`
+	RowsDeletedFromRecent  int64          `json:"rows_deleted_from_recent_watch"`
+	Outliers               []WatchOutlier `json:"outliers"`
+	OutliersDropped        int64          `json:"outliers_dropped"`
+	Errors                 []string       `json:"errors,omitempty"`
+
+	mu            sync.Mutex
+	maxStatements int
+}
+
+// WatchOutlier is a recent_watch row that doesn't look like the others
+type WatchOutlier struct {
+	Reason     string    `json:"reason"`
+	ProfileID  string    `json:"profile_id"`
+	PlayID     string    `json:"play_id,omitempty"`
+	WatchedAt  time.Time `json:"watched_at,omitzero"`
+	Duration   int       `json:"duration,omitempty"`
+	IdlePlays  int       `json:"idle_plays,omitempty"`
+	WatchedNow time.Time `json:"watched_now,omitzero"`
+}
+
+// dryRunWatch scans recent_watch like the watch job does and reports what it
+// would move without writing anything, checkpoints included
+func dryRunWatch(ctx context.Context, t *task, cfg config.JobConfig, workers *Workers) (*WatchReport, error) {
+	w, err := newWatchJobRunner(t, cfg)
+	if err != nil {
+		return nil, err
+	}
+	w.report = &WatchReport{
+		AgeLimit:      (time.Duration(t.configs.TasksConfig.WatchAgeLimit) * time.Hour).String(),
+		maxStatements: w.batches.maxStatements,
+	}
+
+	scanner := w.scanner(t)
+	scanner.checkpoints = nil
+
+	runErr := scanner.Run(ctx, workers)
+	if runErr != nil {
+		w.report.Errors = append(w.report.Errors, runErr.Error())
+	}
+	w.report.FinishedAt = time.Now()
+	return w.report, runErr
+}
+
+// addProfile counts a scanned profile and the outliers among its rows
+func (r *WatchReport) addProfile(runStartedAt time.Time, rows []playInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.RunStartedAt.IsZero() {
+		r.RunStartedAt = runStartedAt
+	}
+	r.ProfilesScanned++
+	r.RowsScanned += int64(len(rows))
+
+	for _, row := range rows {
+		switch {
+		case row.watchedAt.Time().After(runStartedAt):
+			r.addOutlier(WatchOutlier{
+				Reason:    OutlierFutureWatchedAt,
+				ProfileID: row.profile_id.String(),
+				PlayID:    row.play_id.String(),
+				WatchedAt: row.watchedAt.Time(),
+			})
+		case row.duration <= 0:
+			r.addOutlier(WatchOutlier{
+				Reason:    OutlierInvalidDuration,
+				ProfileID: row.profile_id.String(),
+				PlayID:    row.play_id.String(),
+				Duration:  row.duration,
+			})
+		}
+	}
+}
+
+// addMoves counts the writes of the idle plays of a profile, watched holds
+// the current watched_at of the plays found in the watched table
+func (r *WatchReport) addMoves(profileID gocql.UUID, playInfos map[gocql.UUID]playInfo, watched map[gocql.UUID]gocql.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ProfilesWithIdleWatch++
+	if len(playInfos) > r.maxStatements {
+		r.addOutlier(WatchOutlier{
+			Reason:    OutlierLargeProfile,
+			ProfileID: profileID.String(),
+			IdlePlays: len(playInfos),
+		})
+	}
+
+	for playID, info := range playInfos {
+		watchedAt, ok := watched[playID]
+		if !ok {
+			r.addOutlier(WatchOutlier{
+				Reason:    OutlierNotInWatched,
+				ProfileID: profileID.String(),
+				PlayID:    playID.String(),
+				WatchedAt: info.watchedAt.Time(),
+			})
+			continue
+		}
+
+		r.RowsMovedToOrdered++
+		r.RowsDeletedFromRecent++
+		if watchedAt == gocql.UUID(uuid.Nil) || watchedAt == info.watchedAt {
+			continue
+		}
+		r.OutdatedOrderedDeleted++
+		if watchedAt.Time().After(info.watchedAt.Time()) {
+			r.addOutlier(WatchOutlier{
+				Reason:     OutlierOlderThanWatched,
+				ProfileID:  profileID.String(),
+				PlayID:     playID.String(),
+				WatchedAt:  info.watchedAt.Time(),
+				WatchedNow: watchedAt.Time(),
+			})
+		}
+	}
+}
+
+// addError records a profile that could not be looked up
+func (r *WatchReport) addError(profileID gocql.UUID, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Errors = append(r.Errors, profileID.String()+": "+err.Error())
+}
+
+func (r *WatchReport) addOutlier(outlier WatchOutlier) {
+	if len(r.Outliers) >= maxReportOutliers {
+		r.OutliersDropped++
+		return
+	}
+	r.Outliers = append(r.Outliers, outlier)
+}
+
+func (r *WatchReport) String() string {
+	return fmt.Sprintf(
+		"profiles=%d rows=%d moved=%d deleted=%d outliers=%d",
+		r.ProfilesScanned,
+		r.RowsScanned,
+		r.RowsMovedToOrdered,
+		r.RowsDeletedFromRecent,
+		int64(len(r.Outliers))+r.OutliersDropped,
+	)
+}
@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestWatchReport_addMoves(t *testing.T) {
+	now := time.Now()
+	profileID := gocql.TimeUUID()
+	moved := playInfo{watchedAt: gocql.UUIDFromTime(now.Add(-48 * time.Hour)), duration: 60, profile_id: profileID, play_id: gocql.TimeUUID()}
+	replaced := playInfo{watchedAt: gocql.UUIDFromTime(now.Add(-48 * time.Hour)), duration: 60, profile_id: profileID, play_id: gocql.TimeUUID()}
+	older := playInfo{watchedAt: gocql.UUIDFromTime(now.Add(-72 * time.Hour)), duration: 60, profile_id: profileID, play_id: gocql.TimeUUID()}
+	missing := playInfo{watchedAt: gocql.UUIDFromTime(now.Add(-48 * time.Hour)), duration: 60, profile_id: profileID, play_id: gocql.TimeUUID()}
+
+	report := &WatchReport{maxStatements: 50}
+	report.addMoves(
+		profileID,
+		map[gocql.UUID]playInfo{
+			moved.play_id:    moved,
+			replaced.play_id: replaced,
+			older.play_id:    older,
+			missing.play_id:  missing,
+		},
+		map[gocql.UUID]gocql.UUID{
+			moved.play_id:    {},
+			replaced.play_id: gocql.UUIDFromTime(now.Add(-96 * time.Hour)),
+			older.play_id:    gocql.UUIDFromTime(now.Add(-60 * time.Hour)),
+		},
+	)
+
+	if report.RowsMovedToOrdered != 3 || report.RowsDeletedFromRecent != 3 {
+		t.Errorf("moved = %d, deleted = %d, want 3 and 3", report.RowsMovedToOrdered, report.RowsDeletedFromRecent)
+	}
+	if report.OutdatedOrderedDeleted != 2 {
+		t.Errorf("outdated ordered_watch deleted = %d, want 2", report.OutdatedOrderedDeleted)
+	}
+
+	reasons := map[string]string{}
+	for _, outlier := range report.Outliers {
+		reasons[outlier.PlayID] = outlier.Reason
+	}
+	if len(reasons) != 2 || reasons[older.play_id.String()] != OutlierOlderThanWatched || reasons[missing.play_id.String()] != OutlierNotInWatched {
+		t.Errorf("outliers = %+v", report.Outliers)
+	}
+}
@@ -0,0 +1,224 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"mashaghel/internal/config"
+	"mashaghel/internal/database/scylla"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const watchRetentionJobName = "watch_retention"
+
+// defaultWatchRetentionInterval is used when the job has no interval or
+// schedule of its own
+const defaultWatchRetentionInterval = time.Hour
+
+func init() {
+	registerJob(watchRetentionJobName, newWatchRetentionJob)
+}
+
+const (
+	querySelectOrderedWatches = `SELECT token(profile_id), profile_id, watched_at, play_id, duration FROM ordered_watch WHERE token(profile_id) > ? AND token(profile_id) <= ?;`
+	queryDeleteWatched        = `DELETE FROM watched USING TIMESTAMP ? WHERE profile_id = ? AND play_id = ?;`
+	queryDeleteOrderedWatch   = `DELETE FROM ordered_watch WHERE profile_id = ? AND watched_at = ?;`
+)
+
+// orderedWatch is a row of ordered_watch
+type orderedWatch struct {
+	profileID gocql.UUID
+	watchedAt gocql.UUID
+	playID    gocql.UUID
+	duration  int
+}
+
+// watchRetentionJob caps the watch history of every profile in
+// ordered_watch and drops the watched rows of the expired entries
+type watchRetentionJob struct {
+	scylla     scylla.ScyllaDB
+	logger     *zap.Logger
+	maxEntries int
+	maxAge     time.Duration
+	batches    *batchWriter
+	metrics    *jobMetrics
+}
+
+func newWatchRetentionJob(t *task, _ config.JobConfig) (*Job, error) {
+	cfg := t.configs.TasksConfig.WatchRetention
+	if cfg.MaxEntries == 0 && cfg.MaxAge == 0 {
+		return nil, errors.New("watch_retention needs max_entries or max_age")
+	}
+
+	r := &watchRetentionJob{
+		scylla:     t.scylla,
+		logger:     t.logger.With(zap.String("task", watchRetentionJobName)),
+		maxEntries: cfg.MaxEntries,
+		maxAge:     time.Duration(cfg.MaxAge) * 24 * time.Hour,
+		metrics:    t.metrics,
+	}
+	batches, err := newBatchWriter(t.scylla, t.configs.TasksConfig.WatchBatch, watchRetentionJobName, t.metrics, r.logger)
+	if err != nil {
+		return nil, err
+	}
+	r.batches = batches
+
+	scanner := &ringScanner[orderedWatch]{
+		name:        watchRetentionJobName,
+		scylla:      t.scylla,
+		logger:      r.logger,
+		checkpoints: t.checkpoints,
+		configs:     t.ringScanConfig(),
+		query:       querySelectOrderedWatches,
+		scan:        scanOrderedWatch,
+		handle:      r.processProfiles,
+	}
+
+	return &Job{
+		Name:      watchRetentionJobName,
+		Interval:  defaultWatchRetentionInterval,
+		Exclusive: true,
+		Run:       scanner.Run,
+	}, nil
+}
+
+func scanOrderedWatch(scanner gocql.Scanner) (int64, orderedWatch, error) {
+	var token int64
+	var row orderedWatch
+	err := scanner.Scan(&token, &row.profileID, &row.watchedAt, &row.playID, &row.duration)
+	return token, row, err
+}
+
+// processProfiles trims the history of the profiles of a single ordered_watch
+// partition. It returns the errors of the profiles that failed to be
+// trimmed, so the checkpoint stays before the partition and the next run
+// tries it again.
+func (r *watchRetentionJob) processProfiles(ctx context.Context, run scanRun, _ int64, rows []orderedWatch) error {
+	var cutoff time.Time
+	if r.maxAge > 0 {
+		cutoff = run.StartedAt.Add(-r.maxAge)
+	}
+
+	profiles := make(map[gocql.UUID][]orderedWatch)
+	for _, row := range rows {
+		profiles[row.profileID] = append(profiles[row.profileID], row)
+	}
+
+	var errs []error
+	for profileID, profileRows := range profiles {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		r.metrics.ProfilesScanned(ctx, watchRetentionJobName, 1)
+
+		expired := expiredWatches(profileRows, r.maxEntries, cutoff)
+		if len(expired) == 0 {
+			continue
+		}
+		// Chunks keep the `play_id IN ?` lookup of a large history bounded
+		for start := 0; start < len(expired); start += r.batches.maxStatements {
+			chunk := expired[start:min(start+r.batches.maxStatements, len(expired))]
+			if err := r.deleteWatches(ctx, profileID, chunk); err != nil {
+				r.logger.Error("Failed to trim watch history",
+					zap.Error(err),
+					zap.String("profileID", profileID.String()),
+				)
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// expiredWatches returns the entries of a profile past the retention limits:
+// everything after the newest maxEntries and everything watched before
+// cutoff. A zero limit is not applied.
+func expiredWatches(rows []orderedWatch, maxEntries int, cutoff time.Time) []orderedWatch {
+	sorted := append([]orderedWatch(nil), rows...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].watchedAt.Time().After(sorted[j].watchedAt.Time())
+	})
+
+	var expired []orderedWatch
+	for i, row := range sorted {
+		if (maxEntries > 0 && i >= maxEntries) || (!cutoff.IsZero() && row.watchedAt.Time().Before(cutoff)) {
+			expired = append(expired, row)
+		}
+	}
+	return expired
+}
+
+// deleteWatches deletes expired entries of a profile. The watched row of a
+// play is only deleted while it still points at the expired entry, and the
+// entry itself only once that succeeded, so no watched row is left without
+// its ordered_watch entry.
+func (r *watchRetentionJob) deleteWatches(ctx context.Context, profileID gocql.UUID, expired []orderedWatch) error {
+	playIDs := make([]gocql.UUID, 0, len(expired))
+	for _, row := range expired {
+		playIDs = append(playIDs, row.playID)
+	}
+	watched, err := selectWatched(ctx, r.scylla, profileID, playIDs)
+	if err != nil {
+		return err
+	}
+
+	// Watches moved after the lookup are newer than the delete
+	deleteTS := time.Now().UnixNano() / 1000
+
+	var watchedDeletes, orderedDeletes []batchStatement
+	for _, row := range expired {
+		key := row.watchedAt.String()
+		if watched[row.playID] == row.watchedAt {
+			watchedDeletes = append(watchedDeletes, batchStatement{
+				Key:   key,
+				Query: queryDeleteWatched,
+				Args:  []interface{}{deleteTS, profileID, row.playID},
+			})
+		}
+		orderedDeletes = append(orderedDeletes, batchStatement{
+			Key:   key,
+			Query: queryDeleteOrderedWatch,
+			Args:  []interface{}{profileID, row.watchedAt},
+		})
+	}
+
+	failed := r.batches.Write(ctx, watchedDeletes)
+	for key, err := range r.batches.Write(ctx, withoutFailed(orderedDeletes, failed)) {
+		failed[key] = err
+	}
+
+	deleted := len(orderedDeletes) - len(failed)
+	r.metrics.RowsDeleted(ctx, watchRetentionJobName, deleted)
+	trace.SpanFromContext(ctx).AddEvent("watch.trimmed", trace.WithAttributes(
+		attribute.String("watch.profile_id", profileID.String()),
+		attribute.Int("watch.expired", len(expired)),
+		attribute.Int("watch.failed", len(failed)),
+	))
+	r.logger.Info("Trimmed watch history",
+		zap.String("profileID", profileID.String()),
+		zap.Int("deleted", deleted),
+		zap.Int("failed", len(failed)),
+	)
+
+	if len(failed) > 0 {
+		var errs []error
+		seen := make(map[string]bool)
+		for _, err := range failed {
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
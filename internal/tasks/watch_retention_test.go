@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func Test_expiredWatches(t *testing.T) {
+	now := time.Now()
+	// Rows of a profile as ordered_watch returns them, newest first
+	rows := make([]orderedWatch, 5)
+	for i := range rows {
+		rows[i] = orderedWatch{
+			watchedAt: gocql.UUIDFromTime(now.Add(-time.Duration(i) * 24 * time.Hour)),
+			playID:    gocql.TimeUUID(),
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		maxEntries int
+		cutoff     time.Time
+		want       int
+	}{
+		{name: "no limits", want: 0},
+		{name: "max entries", maxEntries: 3, want: 2},
+		{name: "max entries above history", maxEntries: 10, want: 0},
+		{name: "max age", cutoff: now.Add(-36 * time.Hour), want: 3},
+		{name: "both limits", maxEntries: 4, cutoff: now.Add(-60 * time.Hour), want: 2},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			expired := expiredWatches(rows, tt.maxEntries, tt.cutoff)
+			if len(expired) != tt.want {
+				t.Fatalf("expiredWatches() returned %d rows, want %d", len(expired), tt.want)
+			}
+			// Expired rows are always the oldest of the profile
+			for i, row := range expired {
+				if row != rows[len(rows)-len(expired)+i] {
+					t.Fatalf("expiredWatches() expired %v, which is not among the oldest rows", row.watchedAt)
+				}
+			}
+		})
+	}
+}
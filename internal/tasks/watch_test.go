@@ -2,10 +2,14 @@ package tasks
 
 import (
 	"context"
+	"fmt"
+	"mashaghel/internal/config"
 	"mashaghel/internal/database/scylla"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gocql/gocql"
 	"go.uber.org/zap"
 )
 
@@ -83,3 +87,87 @@ func Test_task_processWatched(t *testing.T) {
 		})
 	}
 }
+
+// BenchmarkWatchedLookup compares the ways updateWatches can fetch the
+// watched rows of a profile on a synthetic dataset
+func Test_watchMovedEvent(t *testing.T) {
+	info := playInfo{
+		play_id:    gocql.TimeUUID(),
+		profile_id: gocql.TimeUUID(),
+		watchedAt:  gocql.UUIDFromTime(time.Now().Add(-time.Hour)),
+		duration:   120,
+	}
+
+	event := watchMovedEvent(context.Background(), info)
+	if event.Subject != WatchMovedSubject || event.AggregateKey != info.profile_id.String() {
+		t.Fatalf("watchMovedEvent() = %+v, want an event of profile %s on %s", event, info.profile_id, WatchMovedSubject)
+	}
+	move, ok := event.Event.Payload.(*WatchMove)
+	if !ok || move.PlayID != info.play_id.String() || !move.WatchedAt.Equal(info.watchedAt.Time()) || move.Duration != info.duration {
+		t.Fatalf("watchMovedEvent() payload = %+v, want the move of %+v", event.Event.Payload, info)
+	}
+	if again := watchMovedEvent(context.Background(), info); again.Event.ID != event.Event.ID {
+		t.Fatalf("moving the play again has the event %s, want %s", again.Event.ID, event.Event.ID)
+	}
+}
+
+func BenchmarkWatchedLookup(b *testing.B) {
+	const (
+		profilesCount = 100
+		playsCount    = 20
+	)
+
+	profileIDs := generateIDs(profilesCount)
+	playIDs := generateIDs(playsCount)
+	for _, profileID := range profileIDs {
+		batch := scyllaDB.Session().NewBatch(gocql.UnloggedBatch)
+		for _, playID := range playIDs {
+			batch.Query(`INSERT INTO watched (profile_id, play_id, duration, watched_at) VALUES (?, ?, ?, ?)`,
+				profileID, playID, 60, gocql.TimeUUID())
+		}
+		if err := scyllaDB.Session().ExecuteBatch(batch); err != nil {
+			b.Fatalf("failed to insert synthetic dataset: %v", err)
+		}
+	}
+
+	job := &watchJob{
+		scylla:  scyllaDB,
+		logger:  zap.NewNop(),
+		configs: &config.TasksConfig{},
+	}
+	ctx := context.Background()
+
+	b.Run("string_built_in", func(b *testing.B) {
+		placeHolders := make([]string, len(playIDs))
+		for i, playID := range playIDs {
+			placeHolders[i] = playID.String()
+		}
+		query := fmt.Sprintf(`SELECT play_id, watched_at FROM watched WHERE token(profile_id) = token(?) AND play_id in (%v)`, strings.Join(placeHolders, ","))
+
+		for i := 0; i < b.N; i++ {
+			var playID, watchedAt gocql.UUID
+			iter := scyllaDB.Session().Query(query, profileIDs[i%profilesCount]).Consistency(gocql.One).Iter()
+			for iter.Scan(&playID, &watchedAt) {
+			}
+			if err := iter.Close(); err != nil {
+				b.Fatalf("lookup failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("bound_in", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := job.lookupWatched(ctx, profileIDs[i%profilesCount], playIDs); err != nil {
+				b.Fatalf("lookup failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("per_key_concurrent", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := job.lookupWatchedPerKey(ctx, profileIDs[i%profilesCount], playIDs); err != nil {
+				b.Fatalf("lookup failed: %v", err)
+			}
+		}
+	})
+}
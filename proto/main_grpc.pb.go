@@ -20,6 +20,7 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	RpcService_SayHello_FullMethodName = "/rpc_service.RpcService/SayHello"
+	RpcService_RunJob_FullMethodName   = "/rpc_service.RpcService/RunJob"
 )
 
 // RpcServiceClient is the client API for RpcService service.
@@ -30,6 +31,8 @@ const (
 type RpcServiceClient interface {
 	// Sends a greeting
 	SayHello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloReply, error)
+	// Enqueues an immediate run of a background job
+	RunJob(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (*RunJobReply, error)
 }
 
 type rpcServiceClient struct {
@@ -50,6 +53,16 @@ func (c *rpcServiceClient) SayHello(ctx context.Context, in *HelloRequest, opts
 	return out, nil
 }
 
+func (c *rpcServiceClient) RunJob(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (*RunJobReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RunJobReply)
+	err := c.cc.Invoke(ctx, RpcService_RunJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RpcServiceServer is the server API for RpcService service.
 // All implementations should embed UnimplementedRpcServiceServer
 // for forward compatibility.
@@ -58,6 +71,8 @@ func (c *rpcServiceClient) SayHello(ctx context.Context, in *HelloRequest, opts
 type RpcServiceServer interface {
 	// Sends a greeting
 	SayHello(context.Context, *HelloRequest) (*HelloReply, error)
+	// Enqueues an immediate run of a background job
+	RunJob(context.Context, *RunJobRequest) (*RunJobReply, error)
 }
 
 // UnimplementedRpcServiceServer should be embedded to have
@@ -70,6 +85,9 @@ type UnimplementedRpcServiceServer struct{}
 func (UnimplementedRpcServiceServer) SayHello(context.Context, *HelloRequest) (*HelloReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SayHello not implemented")
 }
+func (UnimplementedRpcServiceServer) RunJob(context.Context, *RunJobRequest) (*RunJobReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunJob not implemented")
+}
 func (UnimplementedRpcServiceServer) testEmbeddedByValue() {}
 
 // UnsafeRpcServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -108,6 +126,24 @@ func _RpcService_SayHello_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RpcService_RunJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RpcServiceServer).RunJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RpcService_RunJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RpcServiceServer).RunJob(ctx, req.(*RunJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // RpcService_ServiceDesc is the grpc.ServiceDesc for RpcService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -119,6 +155,10 @@ var RpcService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SayHello",
 			Handler:    _RpcService_SayHello_Handler,
 		},
+		{
+			MethodName: "RunJob",
+			Handler:    _RpcService_RunJob_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "main.proto",